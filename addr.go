@@ -0,0 +1,12 @@
+package droopy
+
+import (
+	"github.com/353solutions/droopy/internal/netaddr"
+)
+
+// SplitAddr splits a simulator address to network and address.
+// addr can be "host:port", "tcp://host:port", "unix:/path/to/socket" or "unix:///path/to/socket".
+// Listener options such as "?role=observer" (see the -listen flag of droopy) are an error.
+func SplitAddr(addr string) (network, address string, err error) {
+	return netaddr.Split(addr)
+}
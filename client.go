@@ -2,30 +2,168 @@ package droopy
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLineSize is the maximal size of a line from the simulator.
+const maxLineSize = 64 * 1024
+
+var (
+	// ErrMissedEvents is returned by Reconnect when the simulator no longer has the events the client missed.
+	ErrMissedEvents = errors.New("missed events")
+	// ErrClosed is returned when using a closed client.
+	ErrClosed = errors.New("client closed")
+	// ErrBye is returned by the Recv methods when the simulator said BYE and closed the connection,
+	// unless the client reconnects (see WithReconnect).
+	ErrBye = errors.New("simulator said BYE")
 )
 
 // Client is a client to the simulator.
 type Client struct {
-	conn net.Conn
-	scan *bufio.Scanner
+	addr        string
+	tls         *tls.Config
+	token       string
+	dialTimeout time.Duration
+	reconnect   bool
+	afterBye    bool // reconnect after BYE kicked
+	maxBackoff  time.Duration
+	guard       bool
+	dialer      func(ctx context.Context) (net.Conn, error)
+	r           *bufio.Reader
+	bye         string   // reason of the last BYE from the simulator, empty if none
+	partial     []byte   // line read so far, kept when a read is cancelled
+	seq         uint64   // last event sequence number
+	resumable   bool     // the simulator sends sequence numbers, see enableSeq
+	greeted     bool     // the first line after authentication was read, see handshake
+	protocol    bool     // the simulator greeted the client, it knows the protocol commands
+	pending     []string // events received while waiting for a reply
+	state       *State
+	mirror      bool // Sync was called, keep the state in sync with the simulator
+	resync      bool // a command applied to the state was rejected
+
+	wmu     sync.Mutex // serializes writes
+	session []string   // session commands replayed on reconnect, guarded by wmu
+
+	mu     sync.Mutex
+	conn   net.Conn      // replaced on reconnect
+	err    error         // error that stopped Events
+	closed chan struct{} // closed by Close
+	once   sync.Once
 }
 
 type options struct {
-	addr string
+	addr        string
+	tls         *tls.Config
+	token       string
+	dialTimeout time.Duration
+	reconnect   bool
+	afterBye    bool
+	maxBackoff  time.Duration
+	guard       bool
+	dialer      func(ctx context.Context) (net.Conn, error)
 }
 
+// minBackoff is the first delay between reconnect attempts.
+const minBackoff = 100 * time.Millisecond
+
+// helloTimeout is the time to wait for the simulator greeting, older simulators don't send one.
+const helloTimeout = time.Second
+
+// greeting is the first line from a simulator that knows the protocol commands, see sim.Greeting.
+const greeting = "HELLO"
+
 // ClientOption is a function that configures a Client.
 type ClientOption func(*options)
 
-// WithAddr sets the server address for the client.
+// WithAddr sets the server address for the client, see SplitAddr for the address format.
 func WithAddr(addr string) ClientOption {
 	return func(o *options) {
 		o.addr = addr
 	}
 }
 
+// WithTLS connects to the simulator over TLS.
+// Set cfg.Certificates to authenticate with a client certificate,
+// cfg.ServerName must be set when connecting over a Unix socket.
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+// WithToken authenticates to the simulator with token.
+func WithToken(token string) ClientOption {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithDialTimeout limits the time to connect to the simulator, including the handshake.
+// The default is no timeout.
+func WithDialTimeout(timeout time.Duration) ClientOption {
+	return func(o *options) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithReconnect makes the client reconnect when the connection to the simulator is lost.
+// The client waits between attempts, starting at 100ms and doubling up to maxBackoff.
+// The client asks the simulator for sequence numbers (SEQ) when it connects,
+// after reconnecting it sends its session commands again (SUB, UNSUB, ROLE, NAME and LOCK),
+// resumes from the last event it received and asks for a state snapshot.
+// Older simulators crash on commands they don't know, so the client waits up to a second for the
+// simulator greeting (HELLO) when it connects. Without one, the client sends none of these commands:
+// it can't resume and doesn't ask for a snapshot.
+// The Recv methods return a Reconnected event, then the missed events and then a StateReceived event.
+// Sending fails while the client is disconnected, it's the receiving that reconnects.
+// The client reconnects after the simulator says BYE on shutdown as well, e.g. when it's restarted,
+// but not after the simulator kicked it ("BYE kicked"), see WithReconnectAfterBye.
+func WithReconnect(maxBackoff time.Duration) ClientOption {
+	return func(o *options) {
+		o.reconnect = true
+		o.maxBackoff = max(maxBackoff, minBackoff)
+	}
+}
+
+// WithReconnectAfterBye is WithReconnect that reconnects after the simulator kicked the client as well.
+func WithReconnectAfterBye(maxBackoff time.Duration) ClientOption {
+	return func(o *options) {
+		WithReconnect(maxBackoff)(o)
+		o.afterBye = true
+	}
+}
+
+// WithGuard makes the client refuse to send commands that would crash the elevator, according to the client state.
+// Refused commands return an error wrapping ErrUnsafe (e.g. "unsafe command: DO while moving"),
+// unknown commands are refused as well since the simulator crashes on them.
+// The guarded client syncs its state (see Sync) when it connects and after every reconnect,
+// with an older simulator that doesn't know STATE (see WithReconnect) it starts from a reset elevator.
+func WithGuard() ClientOption {
+	return func(o *options) {
+		o.guard = true
+	}
+}
+
+// WithDialer connects to the simulator with dial instead of dialing the client address.
+// It's called on every connection attempt, including reconnects.
+// WithAddr and WithTLS are ignored, token authentication still applies.
+func WithDialer(dial func(ctx context.Context) (net.Conn, error)) ClientOption {
+	return func(o *options) {
+		o.dialer = dial
+	}
+}
+
 // NewClient return new client connected to simulator.
 func NewClient(opts ...ClientOption) (*Client, error) {
 	o := options{
@@ -36,36 +174,861 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 		opt(&o)
 	}
 
-	conn, err := net.Dial("tcp", o.addr)
-	if err != nil {
+	c := Client{
+		addr:        o.addr,
+		tls:         o.tls,
+		token:       o.token,
+		dialTimeout: o.dialTimeout,
+		reconnect:   o.reconnect,
+		afterBye:    o.afterBye,
+		maxBackoff:  o.maxBackoff,
+		guard:       o.guard,
+		dialer:      o.dialer,
+		closed:      make(chan struct{}),
+		state:       NewState(),
+	}
+
+	ctx, cancel := c.dialContext()
+	defer cancel()
+
+	if err := c.dial(ctx); err != nil {
 		return nil, err
 	}
 
-	return &Client{
-		conn: conn,
-		scan: bufio.NewScanner(conn),
-	}, nil
+	// Only resuming needs sequence numbers, older simulators crash on SEQ
+	if c.reconnect {
+		if err := c.handshake(ctx); err != nil {
+			c.getConn().Close()
+			return nil, err
+		}
+	}
+
+	if c.reconnect && c.protocol {
+		if err := c.enableSeq(ctx); err != nil {
+			c.getConn().Close()
+			return nil, err
+		}
+	}
+
+	if c.guard {
+		if err := c.handshake(ctx); err != nil {
+			c.getConn().Close()
+			return nil, err
+		}
+	}
+
+	// The guard is only as good as the state, older simulators crash on STATE
+	if c.guard && c.protocol {
+		if err := c.Sync(ctx); err != nil {
+			c.getConn().Close()
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// dialContext returns a context for connecting to the simulator limited by the dial timeout.
+func (c *Client) dialContext() (context.Context, context.CancelFunc) {
+	if c.dialTimeout > 0 {
+		return context.WithTimeout(context.Background(), c.dialTimeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+func (c *Client) dial(ctx context.Context) error {
+	network, addr, err := SplitAddr(c.addr)
+	if err != nil {
+		return err
+	}
+
+	var conn net.Conn
+	switch {
+	case c.dialer != nil:
+		conn, err = c.dialer(ctx)
+	case c.tls != nil:
+		d := tls.Dialer{Config: c.tls}
+		conn, err = d.DialContext(ctx, network, addr)
+	default:
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.setConn(conn); err != nil {
+		return err
+	}
+	c.r = bufio.NewReader(conn)
+	c.partial = nil
+
+	// The reply to AUTH comes before the greeting
+	c.greeted, c.protocol = true, false
+	if c.token != "" {
+		if err := c.auth(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	c.greeted = false
+
+	return nil
+}
+
+// handshake waits for the simulator greeting on a new connection, up to helloTimeout.
+// Without a greeting the simulator is an older one, it doesn't know the protocol commands (SEQ, STATE, SUB...).
+// A line that's not the greeting is kept for Recv.
+func (c *Client) handshake(ctx context.Context) error {
+	if c.greeted {
+		return nil
+	}
+
+	// The connection deadline might fire before ctx notices, ctx.Err can't tell whose deadline it was
+	deadline, ok := ctx.Deadline()
+	ours := !ok || deadline.After(time.Now().Add(helloTimeout))
+
+	hctx, cancel := context.WithTimeout(ctx, helloTimeout)
+	defer cancel()
+
+	line, err := c.readRawLine(hctx)
+	switch {
+	case err == nil:
+	case ours && errors.Is(err, context.DeadlineExceeded):
+		// Older simulators are silent until there's an event
+		c.greeted = true
+		return nil
+	default:
+		return err
+	}
+
+	c.greeted = true
+	if line == greeting {
+		c.protocol = true
+		return nil
+	}
+
+	c.apply(line)
+	c.pending = append(c.pending, c.event(line))
+	return nil
+}
+
+// auth authenticates with the client token.
+func (c *Client) auth(ctx context.Context) error {
+	if err := c.SendContext(ctx, "AUTH "+c.token); err != nil {
+		return err
+	}
+
+	reply, err := c.readLine(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication: %w", err)
+	}
+
+	if reply != "OK AUTH" {
+		return fmt.Errorf("authentication: %s", reply)
+	}
+
+	return nil
+}
+
+// enableSeq asks the simulator to add sequence numbers to events, only send it after the greeting.
+// If the simulator rejects SEQ, the client goes on without sequence numbers and can't resume.
+func (c *Client) enableSeq(ctx context.Context) error {
+	if err := c.SendContext(ctx, "SEQ"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.readLine(ctx)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "ERR SEQ:"):
+			c.resumable = false
+			return nil
+		case isReplyTo("SEQ", line):
+			var seq uint64
+			if _, err := fmt.Sscanf(line, "OK SEQ %d", &seq); err != nil {
+				return fmt.Errorf("bad SEQ reply: %q", line)
+			}
+
+			c.seq, c.resumable = seq, true
+			return nil
+		default:
+			c.pending = append(c.pending, c.event(line))
+		}
+	}
+}
+
+// request sends cmd and returns the "OK" or "ERR" reply line.
+// Events received before the reply are kept for Recv if keep is true.
+func (c *Client) request(ctx context.Context, cmd string, keep bool) (string, error) {
+	if err := c.SendContext(ctx, cmd); err != nil {
+		return "", err
+	}
+
+	name := strings.Fields(cmd)[0]
+	for {
+		line, err := c.readLine(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if isReplyTo(name, line) {
+			return line, nil
+		}
+
+		if keep {
+			c.pending = append(c.pending, c.event(line))
+		}
+	}
+}
+
+// isReplyTo reports if line is the reply to the request name, STATE is answered with a snapshot.
+func isReplyTo(name, line string) bool {
+	if strings.HasPrefix(line, "ERR "+name+":") {
+		return true
+	}
+
+	if name == "STATE" {
+		return strings.HasPrefix(line, "STATE ")
+	}
+
+	return strings.HasPrefix(line, "OK "+name)
+}
+
+// Reconnect reconnects to the simulator and resumes from the last received event,
+// events sent while the client was disconnected are returned by Recv.
+// The session commands sent by the client (SUB, UNSUB, ROLE, NAME and LOCK) are sent again first.
+// If the simulator no longer has these events, Reconnect returns an error wrapping ErrMissedEvents.
+// The client is connected in this case as well.
+// Resuming needs sequence numbers, without WithReconnect Reconnect always returns ErrMissedEvents.
+func (c *Client) Reconnect() error {
+	ctx, cancel := c.dialContext()
+	defer cancel()
+
+	err := c.resume(ctx)
+	if !c.mirror || !c.protocol || (err != nil && !errors.Is(err, ErrMissedEvents)) {
+		return err
+	}
+
+	if serr := c.Sync(ctx); serr != nil {
+		return serr
+	}
+
+	return err
+}
+
+// resume reconnects and resumes from the last received event, see Reconnect.
+func (c *Client) resume(ctx context.Context) error {
+	c.getConn().Close()
+	c.pending = nil
+	if err := c.dial(ctx); err != nil {
+		return err
+	}
+
+	if err := c.handshake(ctx); err != nil {
+		return err
+	}
+
+	// An older simulator crashes on the session commands
+	if !c.protocol {
+		c.resumable = false
+		return fmt.Errorf("%w: no sequence numbers", ErrMissedEvents)
+	}
+
+	// Before RESUME, so the replayed events match the subscriptions
+	if err := c.replaySession(ctx, !c.resumable); err != nil {
+		return err
+	}
+
+	if !c.resumable {
+		// E.g. the previous simulator was an older one
+		if c.reconnect {
+			if err := c.enableSeq(ctx); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("%w: no sequence numbers", ErrMissedEvents)
+	}
+
+	// Events before the reply are replayed
+	reply, err := c.request(ctx, fmt.Sprintf("RESUME %d", c.seq), false)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(reply, "ERR") {
+		if err := c.enableSeq(ctx); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", ErrMissedEvents, reply)
+	}
+
+	return nil
+}
+
+// reconnectOnce is a single autoReconnect attempt, limited by the dial timeout.
+func (c *Client) reconnectOnce(ctx context.Context) error {
+	if c.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.dialTimeout)
+		defer cancel()
+	}
+
+	notice := "RECONNECTED"
+	if err := c.resume(ctx); err != nil {
+		if !errors.Is(err, ErrMissedEvents) {
+			return err
+		}
+		notice += " " + err.Error()
+	}
+
+	// An older simulator doesn't have STATE
+	if !c.protocol {
+		c.pending = append([]string{notice}, c.pending...)
+		return nil
+	}
+
+	// The replayed events are kept in pending
+	snapshot, err := c.request(ctx, "STATE", true)
+	if err != nil {
+		return err
+	}
+
+	// Recv returns the rejection instead of the snapshot, the next RecvContext tries again
+	if strings.HasPrefix(snapshot, "ERR") && c.mirror {
+		c.resync = true
+	}
+
+	c.pending = append([]string{notice}, append(c.pending, snapshot)...)
+	return nil
 }
 
 // Send sends a command to the client.
 func (c *Client) Send(cmd string) error {
-	_, err := fmt.Fprintln(c.conn, cmd)
+	return c.SendContext(context.Background(), cmd)
+}
+
+// SendContext sends a command to the simulator, it fails if ctx is done before the command is sent.
+// If SendContext fails, the command might have been partially sent and the client should be closed.
+// It's safe to send from several goroutines.
+func (c *Client) SendContext(ctx context.Context, cmd string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	parsed, perr := ParseCommand(cmd)
+	switch {
+	case !c.guard:
+	case perr == nil:
+		if err := c.state.View().Check(parsed); err != nil {
+			return err
+		}
+	case !isProtocolCmd(cmd):
+		// The simulator crashes on unknown commands
+		return fmt.Errorf("%w: %s", ErrUnsafe, perr)
+	}
+
+	conn := c.getConn()
+	stop := watchContext(ctx, conn.SetWriteDeadline)
+	defer stop()
+
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return connError(ctx, err)
+	}
+
+	if perr == nil {
+		c.state.ApplyCommand(parsed)
+	} else {
+		c.recordSession(cmd)
+	}
+
+	return nil
+}
+
+// sessionKey returns the key of a session command (e.g. "ROLE" for "ROLE observer"),
+// only the last command with a key is replayed. It returns false if cmd is not a session command.
+func sessionKey(cmd string) (string, bool) {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	switch fields[0] {
+	case "ROLE", "NAME":
+		return fields[0], true
+	case "LOCK", "UNLOCK":
+		return "LOCK", true
+	case "SUB", "UNSUB":
+		// The last of SUB tick and UNSUB tick wins
+		return "SUB " + strings.Join(fields[1:], " "), true
+	}
+
+	return "", false
+}
+
+// recordSession records cmd if it's a session command, c.wmu must be held.
+func (c *Client) recordSession(cmd string) {
+	key, ok := sessionKey(cmd)
+	if !ok {
+		return
+	}
+
+	c.session = slices.DeleteFunc(c.session, func(s string) bool {
+		k, _ := sessionKey(s)
+		return k == key
+	})
+
+	// A new connection isn't locked
+	if cmd != "UNLOCK" {
+		c.session = append(c.session, cmd)
+	}
+}
+
+// replaySession sends the session commands (subscriptions, role, name and lock) on a new connection.
+// Rejected commands are returned by Recv, events received meanwhile are kept if keep is true.
+func (c *Client) replaySession(ctx context.Context, keep bool) error {
+	c.wmu.Lock()
+	cmds := slices.Clone(c.session)
+	c.wmu.Unlock()
+
+	for _, cmd := range cmds {
+		reply, err := c.request(ctx, cmd, keep)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(reply, "ERR") {
+			c.pending = append(c.pending, reply)
+		}
+	}
+
+	return nil
+}
+
+// protocolCmds are the commands that are not elevator commands.
+var protocolCmds = map[string]bool{
+	"AUTH":   true,
+	"SEQ":    true,
+	"RESUME": true,
+	"STATE":  true,
+	"SUB":    true,
+	"UNSUB":  true,
+	"ROLE":   true,
+	"NAME":   true,
+	"LOCK":   true,
+	"UNLOCK": true,
+	"PONG":   true,
+}
+
+// isProtocolCmd reports if cmd is a protocol command (e.g. "SUB tick").
+func isProtocolCmd(cmd string) bool {
+	fields := strings.Fields(cmd)
+	return len(fields) > 0 && protocolCmds[fields[0]]
+}
+
+func (c *Client) getConn() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// setConn makes conn the client connection.
+// If the client was closed while dialing, conn is closed and setConn returns ErrClosed.
+func (c *Client) setConn(conn net.Conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closed:
+		conn.Close()
+		return ErrClosed
+	default:
+	}
+
+	c.conn = conn
+	return nil
+}
+
+// shouldReconnect reports if the client should reconnect after a receive error.
+func (c *Client) shouldReconnect(ctx context.Context, err error) bool {
+	if !c.reconnect || ctx.Err() != nil || errors.Is(err, ErrClosed) || (c.bye == "kicked" && !c.afterBye) {
+		return false
+	}
+
+	select {
+	case <-c.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// autoReconnect reconnects with exponential backoff until it succeeds, ctx is done or the client is closed.
+// On success the pending events are a Reconnected event, the missed events and a StateReceived event.
+func (c *Client) autoReconnect(ctx context.Context) error {
+	delay := minBackoff
+	for {
+		err := c.reconnectOnce(ctx)
+		if err == nil || errors.Is(err, ErrClosed) {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closed:
+			return ErrClosed
+		}
+		delay = min(delay*2, c.maxBackoff)
+	}
+}
+
+// watchContext applies the deadline and cancellation of ctx to the connection using set,
+// until the returned stop function is called.
+func watchContext(ctx context.Context, set func(time.Time) error) (stop func()) {
+	deadline, _ := ctx.Deadline() // zero (no deadline) if there's none
+	set(deadline)
+
+	fired := make(chan struct{})
+	stopFunc := context.AfterFunc(ctx, func() {
+		defer close(fired)
+		set(time.Unix(1, 0)) // in the past, pending I/O fails now
+	})
+
+	return func() {
+		if !stopFunc() {
+			<-fired
+		}
+	}
+}
+
+// connError returns the ctx error if err is due to ctx and ErrClosed if err is due to Close.
+func connError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	if errors.Is(err, net.ErrClosed) {
+		return ErrClosed
+	}
+
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		// The connection deadline passed before ctx noticed
+		return context.DeadlineExceeded
+	}
+
 	return err
 }
 
+// readLine reads the next line from the simulator, it answers heartbeats.
+func (c *Client) readLine(ctx context.Context) (string, error) {
+	for {
+		line, err := c.readRawLine(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if !c.greeted {
+			c.greeted = true
+			if line == greeting {
+				c.protocol = true
+				continue
+			}
+		}
+
+		if line != "PING" {
+			c.apply(line)
+			return line, nil
+		}
+
+		if err := c.SendContext(ctx, "PONG"); err != nil {
+			return "", err
+		}
+	}
+}
+
+// readRawLine reads the next line from the simulator.
+// If ctx is done mid line, what was read so far is kept for the next call.
+func (c *Client) readRawLine(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	stop := watchContext(ctx, c.getConn().SetReadDeadline)
+	defer stop()
+
+	for {
+		data, err := c.r.ReadSlice('\n')
+		c.partial = append(c.partial, data...)
+		if len(c.partial) > maxLineSize {
+			return "", fmt.Errorf("line too long (max is %d bytes)", maxLineSize)
+		}
+
+		switch {
+		case err == nil:
+			line := strings.TrimRight(string(c.partial), "\r\n")
+			c.partial = c.partial[:0]
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			// Long line, keep reading
+		case errors.Is(err, io.EOF):
+			return "", fmt.Errorf("connection closed")
+		default:
+			return "", connError(ctx, err)
+		}
+	}
+}
+
+// apply updates the state from a line as soon as it's read, so the state follows the simulator order
+// even if the line is kept in pending.
+func (c *Client) apply(line string) {
+	line, _, _ = splitSeq(line)
+	evt, err := ParseEvent(line)
+	if err != nil {
+		return
+	}
+
+	c.state.ApplyEvent(evt)
+
+	// Commands are applied to the state when sent, a rejected command needs a snapshot
+	if _, err := ParseCommand(evt.Cmd); evt.Kind == Rejected && err == nil && c.mirror {
+		c.resync = true
+	}
+}
+
+// event strips the sequence number from an event line and records it.
+func (c *Client) event(line string) string {
+	line, seq, ok := splitSeq(line)
+	if ok {
+		c.seq = seq
+	}
+
+	return line
+}
+
+// splitSeq splits an event line to the event and its sequence number, ok is false if there's no sequence number.
+func splitSeq(line string) (evt string, seq uint64, ok bool) {
+	i := strings.LastIndex(line, " #")
+	if i == -1 {
+		return line, 0, false
+	}
+
+	seq, err := strconv.ParseUint(line[i+2:], 10, 64)
+	if err != nil {
+		return line, 0, false
+	}
+
+	return line[:i], seq, true
+}
+
 // Recv receives an event from the simulator, blocking until there's one.
 func (c *Client) Recv() (string, error) {
-	if !c.scan.Scan() {
-		if err := c.scan.Err(); err != nil {
+	return c.RecvContext(context.Background())
+}
+
+// RecvContext receives an event from the simulator, blocking until there's one or ctx is done.
+// A cancelled RecvContext doesn't lose events, the next call gets them.
+func (c *Client) RecvContext(ctx context.Context) (string, error) {
+	line, err := c.recv(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// On failure the next call tries again
+	if c.resync && c.sync(ctx) == nil {
+		c.resync = false
+	}
+
+	return line, nil
+}
+
+// recv returns the next pending event or reads one, reconnecting if needed.
+func (c *Client) recv(ctx context.Context) (string, error) {
+	for {
+		if len(c.pending) > 0 {
+			evt := c.pending[0]
+			c.pending = c.pending[1:]
+			return evt, nil
+		}
+
+		line, err := c.readLine(ctx)
+		if err == nil {
+			line = c.event(line)
+			c.bye = ""
+			if reason, ok := strings.CutPrefix(line, "BYE "); ok {
+				c.bye = reason
+			}
+			return line, nil
+		}
+
+		if !c.shouldReconnect(ctx, err) {
+			if c.bye != "" && ctx.Err() == nil && !errors.Is(err, ErrClosed) {
+				return "", fmt.Errorf("%w: %s", ErrBye, c.bye)
+			}
 			return "", err
 		}
-		return "", fmt.Errorf("connection closed")
+
+		if err := c.autoReconnect(ctx); err != nil {
+			return "", err
+		}
+	}
+}
+
+// isReply reports if line is a reply to a request (e.g. "OK ROLE observer") rather than an event.
+func isReply(line string) bool {
+	return strings.HasPrefix(line, "OK ")
+}
+
+// RecvEvent receives the next event from the simulator, skipping replies to requests.
+// It returns an error for lines that aren't valid events.
+func (c *Client) RecvEvent(ctx context.Context) (Event, error) {
+	for {
+		line, err := c.RecvContext(ctx)
+		if err != nil {
+			return Event{}, err
+		}
+
+		if isReply(line) {
+			continue
+		}
+
+		return ParseEvent(line)
+	}
+}
+
+// SendCommand sends cmd to the simulator, invalid commands are not sent.
+func (c *Client) SendCommand(ctx context.Context, cmd Command) error {
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+
+	return c.SendContext(ctx, cmd.String())
+}
+
+// Events starts a reader goroutine that sends events to the returned channel.
+// The channel is closed when ctx is done or on error (including malformed events), Err returns the error.
+// Don't call the Recv methods while the channel is open, sending is fine.
+func (c *Client) Events(ctx context.Context) <-chan Event {
+	c.setErr(nil)
+
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+
+		for {
+			evt, err := c.RecvEvent(ctx)
+			if err != nil {
+				c.setErr(err)
+				return
+			}
+
+			select {
+			case ch <- evt:
+			case <-ctx.Done():
+				c.setErr(ctx.Err())
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+func (c *Client) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// Err returns the error that closed the Events channel.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// State returns the simulator state mirrored by the client.
+// It's updated from events received and commands sent by the client,
+// call Sync to start from the simulator state and keep up with crashes and resets.
+func (c *Client) State() *State {
+	return c.state
+}
+
+// Sync updates the client state from a simulator state snapshot.
+// It subscribes to crash events (CRASH and RESET) so the state sees them,
+// and from now on a rejected command (e.g. ERR MU) updates the state from a new snapshot.
+// Events received while waiting for the snapshot are returned by Recv.
+// With an older simulator (see WithReconnect), Sync sends nothing and returns an error wrapping errors.ErrUnsupported.
+// Don't call Sync while another goroutine receives.
+func (c *Client) Sync(ctx context.Context) error {
+	if err := c.handshake(ctx); err != nil {
+		return err
+	}
+
+	if !c.protocol {
+		return fmt.Errorf("sync: %w: the simulator doesn't know STATE", errors.ErrUnsupported)
+	}
+
+	if err := c.subscribeCrash(ctx); err != nil {
+		return err
+	}
+
+	c.mirror = true
+	return c.sync(ctx)
+}
+
+// subscribeCrash subscribes to crash events for the state mirror.
+func (c *Client) subscribeCrash(ctx context.Context) error {
+	reply, err := c.request(ctx, "SUB crash", true)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(reply, "ERR") {
+		return fmt.Errorf("subscribe: %s", reply)
+	}
+
+	return nil
+}
+
+// sync updates the client state from a simulator state snapshot, the snapshot is applied by readLine.
+// A rejected STATE (e.g. rate limited) is an error.
+func (c *Client) sync(ctx context.Context) error {
+	line, err := c.request(ctx, "STATE", true)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(line, "ERR") {
+		return fmt.Errorf("sync: %s", line)
+	}
+
+	if _, err := ParseEvent(line); err != nil {
+		return err
 	}
 
-	return c.scan.Text(), nil
+	return nil
 }
 
 // Close closes the client.
 func (c *Client) Close() error {
-	return c.conn.Close()
+	c.once.Do(func() { close(c.closed) })
+	return c.getConn().Close()
 }
@@ -1,73 +1,45 @@
 package droopy
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
-)
-
-func waitForServer(t *testing.T, addr string, timeout time.Duration) {
-	addr = "localhost" + addr
-	start := time.Now()
-	for time.Since(start) <= timeout {
-		conn, err := net.Dial("tcp", addr)
-		if err == nil {
-			conn.Close()
-			return
-		}
-
-		time.Sleep(100 * time.Millisecond)
-	}
 
-	t.Fatalf("server on %s did not start after %v", addr, timeout)
-}
-
-func startElevator(t *testing.T, addr string) {
-	tmpDir := t.TempDir()
-	binPath := filepath.Join(tmpDir, "droopy")
-
-	buildCmd := exec.Command("go", "build", "-o", binPath, "./cmd/droopy")
-	if out, err := buildCmd.CombinedOutput(); err != nil {
-		t.Fatalf("failed to build droopy: %v\n%s", err, out)
-	}
-
-	cmd := exec.Command(binPath, "-addr", addr)
-	if _, err := cmd.StdinPipe(); err != nil {
-		t.Fatalf("failed to create stdin pipe: %v", err)
-	}
+	"github.com/353solutions/droopy/sim"
+)
 
-	if err := cmd.Start(); err != nil {
-		t.Fatalf("failed to start droopy: %v", err)
-	}
-	t.Cleanup(func() {
-		if err := cmd.Process.Kill(); err != nil {
-			t.Logf("warning: can't kill %d - %v", cmd.Process.Pid, err)
-		}
-	})
+// startSim starts an in-process simulator listening on addr (e.g. "localhost:0") and returns its address.
+func startSim(t *testing.T, opts sim.Options, addr string) string {
+	t.Helper()
 
-	waitForServer(t, addr, 2*time.Second)
-}
+	s := sim.New(opts)
+	t.Cleanup(func() { s.Close() })
 
-func freePort(t *testing.T) int {
-	lst, err := net.Listen("tcp", "localhost:0")
+	lis, err := s.Listen(addr)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer lst.Close()
-	return lst.Addr().(*net.TCPAddr).Port
+	go s.Run(context.Background())
+	if lis.Addr().Network() == "unix" {
+		return "unix:" + lis.Addr().String()
+	}
+	return lis.Addr().String()
 }
 
 func TestClient(t *testing.T) {
-	port := freePort(t)
-	addr := fmt.Sprintf(":%d", port)
-	startElevator(t, addr)
+	addr := startSim(t, sim.Options{}, "localhost:0")
 
 	// Create client
-	c, err := NewClient(WithAddr("localhost" + addr))
+	c, err := NewClient(WithAddr(addr))
 	if err != nil {
 		t.Fatalf("failed to create client: %v", err)
 	}
@@ -102,3 +74,962 @@ func TestClient(t *testing.T) {
 		t.Errorf("expected stopped event S2, got %q", evt)
 	}
 }
+
+func TestClient_Reconnect(t *testing.T) {
+	addr := startSim(t, sim.Options{}, "localhost:0")
+
+	// Resuming needs the sequence numbers of WithReconnect
+	c, err := NewClient(WithAddr(addr), WithReconnect(time.Second))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("P2"); err != nil {
+		t.Fatalf("failed to send P2: %v", err)
+	}
+
+	if evt, err := c.Recv(); err != nil || evt != "P2" {
+		t.Fatalf("expected P2, got %q (err=%v)", evt, err)
+	}
+
+	// Lost connection, a closed client doesn't reconnect
+	c.getConn().Close()
+
+	other, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer other.Close()
+
+	for _, cmd := range []string{"ROLE passenger", "P3", "U1"} {
+		if err := other.Send(cmd); err != nil {
+			t.Fatalf("failed to send %s: %v", cmd, err)
+		}
+	}
+
+	// Wait for the events to be sent
+	for _, want := range []string{"OK ROLE passenger", "P3", "U1"} {
+		if evt, err := other.Recv(); err != nil || evt != want {
+			t.Fatalf("other: expected %s, got %q (err=%v)", want, evt, err)
+		}
+	}
+
+	if err := c.Reconnect(); err != nil {
+		t.Fatalf("failed to reconnect: %v", err)
+	}
+
+	for _, want := range []string{"P3", "U1"} {
+		if evt, err := c.Recv(); err != nil || evt != want {
+			t.Fatalf("expected %s, got %q (err=%v)", want, evt, err)
+		}
+	}
+}
+
+func TestClient_Heartbeat(t *testing.T) {
+	opts := sim.Options{
+		Heartbeat:        20 * time.Millisecond,
+		HeartbeatTimeout: 100 * time.Millisecond,
+		Failsafe:         true,
+	}
+	addr := startSim(t, opts, "localhost:0")
+
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("MU"); err != nil {
+		t.Fatalf("failed to send motor up: %v", err)
+	}
+
+	// Failsafe would stop the car before reaching floor 2 if the client didn't answer PING
+	for _, want := range []string{"A2", "A3"} {
+		evt, err := c.Recv()
+		if err != nil {
+			t.Fatalf("failed to receive event: %v", err)
+		}
+
+		if evt != want {
+			t.Fatalf("expected %s, got %q", want, evt)
+		}
+	}
+}
+
+func TestClient_Unix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "droopy.sock")
+	addr := startSim(t, sim.Options{}, "unix:"+sock)
+
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("P3"); err != nil {
+		t.Fatalf("failed to send P3: %v", err)
+	}
+
+	evt, err := c.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+
+	if evt != "P3" {
+		t.Fatalf("expected P3, got %q", evt)
+	}
+}
+
+func TestClient_Token(t *testing.T) {
+	addr := startSim(t, sim.Options{Token: "s3cr3t"}, "localhost:0")
+
+	c, err := NewClient(WithAddr(addr), WithToken("s3cr3t"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("P2"); err != nil {
+		t.Fatalf("failed to send P2: %v", err)
+	}
+
+	if evt, err := c.Recv(); err != nil || evt != "P2" {
+		t.Fatalf("expected P2, got %q (err=%v)", evt, err)
+	}
+
+	if c, err := NewClient(WithAddr(addr), WithToken("guess")); err == nil {
+		c.Close()
+		t.Fatal("expected error with bad token")
+	}
+
+	c, err = NewClient(WithAddr(addr))
+	expectRejected(t, c, err)
+}
+
+// expectRejected fails t if the simulator accepted the client returned by NewClient.
+// Without a token or SEQ handshake NewClient doesn't read from the simulator, the rejection is seen by Recv.
+func expectRejected(t *testing.T, c *Client, err error) {
+	t.Helper()
+
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	c.Send("P2") // might fail on a closed connection
+	if evt, err := c.Recv(); err == nil && !strings.HasPrefix(evt, "ERR AUTH") {
+		t.Fatalf("expected rejection, got %q", evt)
+	}
+}
+
+// fakeServer accepts a single client and greets it, the connection is sent on the returned channel.
+func fakeServer(t *testing.T) (string, <-chan net.Conn) {
+	t.Helper()
+	return acceptOne(t, true, "")
+}
+
+// fakeServerSeq is fakeServer for a client WithReconnect, the SEQ handshake is answered with reply.
+func fakeServerSeq(t *testing.T, reply string) (string, <-chan net.Conn) {
+	t.Helper()
+	return acceptOne(t, true, reply)
+}
+
+// oldServer is fakeServer for an older simulator that doesn't greet.
+func oldServer(t *testing.T) (string, <-chan net.Conn) {
+	t.Helper()
+	return acceptOne(t, false, "")
+}
+
+// acceptOne accepts a single client, greets it if greet is true and answers SEQ with reply if it's not empty.
+func acceptOne(t *testing.T, greet bool, reply string) (string, <-chan net.Conn) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	ch := make(chan net.Conn, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		if greet {
+			fmt.Fprintln(conn, "HELLO")
+		}
+
+		if reply != "" {
+			s := bufio.NewScanner(conn)
+			if s.Scan() && s.Text() == "SEQ" {
+				fmt.Fprintln(conn, reply)
+			}
+		}
+		ch <- conn
+	}()
+
+	return lis.Addr().String(), ch
+}
+
+func TestClient_Seq(t *testing.T) {
+	t.Run("no reconnect", func(t *testing.T) {
+		addr, conns := fakeServer(t)
+		c, err := NewClient(WithAddr(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		srv := <-conns
+
+		if err := c.Send("P2"); err != nil {
+			t.Fatal(err)
+		}
+
+		// No SEQ before the command
+		expectLine(t, srv, bufio.NewScanner(srv), "P2")
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		addr, conns := fakeServerSeq(t, "ERR SEQ: unknown command")
+		c, err := NewClient(WithAddr(addr), WithReconnect(time.Second), WithDialTimeout(5*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		<-conns
+
+		if err := c.Reconnect(); !errors.Is(err, ErrMissedEvents) {
+			t.Fatalf("expected missed events, got %v", err)
+		}
+	})
+
+	// Older simulators don't greet and crash on SEQ
+	t.Run("old simulator", func(t *testing.T) {
+		addr, conns := oldServer(t)
+		start := time.Now()
+		c, err := NewClient(WithAddr(addr), WithReconnect(time.Second), WithDialTimeout(5*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		srv := <-conns
+
+		if d := time.Since(start); d > 2*helloTimeout {
+			t.Fatalf("handshake took too long: %v", d)
+		}
+
+		if err := c.Send("P2"); err != nil {
+			t.Fatal(err)
+		}
+
+		// No SEQ before the command
+		expectLine(t, srv, bufio.NewScanner(srv), "P2")
+	})
+}
+
+func TestClient_RecvContext(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := <-conns
+
+	// Partial line, must survive the timeout
+	fmt.Fprint(srv, "A")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.RecvContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := c.RecvContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+
+	fmt.Fprint(srv, "2 #1\n")
+	if evt, err := c.Recv(); err != nil || evt != "A2" {
+		t.Fatalf("expected A2, got %q (err=%v)", evt, err)
+	}
+}
+
+func TestClient_SendContext(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-conns
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.SendContext(ctx, "MU"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+}
+
+func TestClient_DialTimeout(t *testing.T) {
+	// Server that never greets, the dial timeout is shorter than the greeting wait
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	start := time.Now()
+	_, err = NewClient(WithAddr(lis.Addr().String()), WithDialTimeout(100*time.Millisecond), WithReconnect(time.Second))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("timeout took too long: %v", d)
+	}
+}
+
+func TestClient_RecvEvent(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := <-conns
+
+	fmt.Fprint(srv, "OK ROLE controller\nA2 #1\nA9 #2\n")
+	ctx := context.Background()
+	evt, err := c.RecvEvent(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Event{Kind: Approaching, Floor: 2}); evt != want {
+		t.Fatalf("expected %+v, got %+v", want, evt)
+	}
+
+	if evt, err := c.RecvEvent(ctx); err == nil {
+		t.Fatalf("expected error, got %+v", evt)
+	}
+}
+
+func TestClient_SendCommand(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := <-conns
+
+	ctx := context.Background()
+	if err := c.SendCommand(ctx, ClearPanel(5)); err == nil {
+		t.Fatal("expected error for invalid command")
+	}
+
+	if err := c.SendCommand(ctx, ClearPanel(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SetReadDeadline(time.Now().Add(time.Second))
+	s := bufio.NewScanner(srv)
+	if !s.Scan() || s.Text() != "CP3" {
+		t.Fatalf("expected CP3, got %q (err=%v)", s.Text(), s.Err())
+	}
+}
+
+func TestClient_Events(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := <-conns
+
+	events := c.Events(context.Background())
+
+	// Concurrent senders
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Send(PressPanel(i%MaxFloor + 1).String()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	srv.SetReadDeadline(time.Now().Add(time.Second))
+	s := bufio.NewScanner(srv)
+	for range 10 {
+		if !s.Scan() {
+			t.Fatalf("can't read command: %v", s.Err())
+		}
+
+		if _, err := ParseCommand(s.Text()); err != nil {
+			t.Fatalf("garbled command: %v", err)
+		}
+	}
+
+	fmt.Fprint(srv, "P2 #1\nA2 #2\n")
+	for _, want := range []Event{{Kind: PanelPressed, Floor: 2}, {Kind: Approaching, Floor: 2}} {
+		if evt := <-events; evt != want {
+			t.Fatalf("expected %+v, got %+v", want, evt)
+		}
+	}
+
+	srv.Close()
+	if evt, ok := <-events; ok {
+		t.Fatalf("expected closed channel, got %+v", evt)
+	}
+
+	if c.Err() == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestClient_EventsCancel(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-conns
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := c.Events(ctx)
+	cancel()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("expected closed channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+
+	if err := c.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+}
+
+// expectLine reads a line from conn and fails if it's not want.
+func expectLine(t *testing.T, conn net.Conn, s *bufio.Scanner, want string) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if !s.Scan() {
+		t.Errorf("expected %q, got error: %v", want, s.Err())
+		return
+	}
+
+	if line := s.Text(); line != want {
+		t.Errorf("expected %q, got %q", want, line)
+	}
+}
+
+func TestClient_AutoReconnect(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	go func() {
+		// First connection, the simulator shuts down after an event
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		s := bufio.NewScanner(conn)
+		fmt.Fprintln(conn, "HELLO")
+		expectLine(t, conn, s, "SEQ")
+		fmt.Fprint(conn, "OK SEQ 0\nP2 #1\nBYE quit\n")
+		time.Sleep(50 * time.Millisecond)
+		conn.Close()
+
+		// Simulator restart
+		time.Sleep(150 * time.Millisecond)
+		conn, err = lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s = bufio.NewScanner(conn)
+		fmt.Fprintln(conn, "HELLO")
+		expectLine(t, conn, s, "RESUME 1")
+		fmt.Fprint(conn, "OK RESUME 2\nU3 #2\n")
+		expectLine(t, conn, s, "STATE")
+		fmt.Fprint(conn, `STATE {"floor":1,"motor":"OFF","door":"CLOSED","panel":[2],"up":[3],"down":[]}`+"\n")
+		time.Sleep(time.Second)
+	}()
+
+	c, err := NewClient(WithAddr(lis.Addr().String()), WithReconnect(200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, want := range []Kind{PanelPressed, Bye, Reconnected, UpPressed, StateReceived} {
+		evt, err := c.RecvEvent(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if evt.Kind != want {
+			t.Fatalf("expected %s, got %s", want, evt)
+		}
+	}
+}
+
+func TestClient_ReconnectSession(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	session := []string{"ROLE observer", "SUB tick", "UNSUB door", "LOCK", "UNLOCK", "ROLE passenger", "NAME bob"}
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		s := bufio.NewScanner(conn)
+		fmt.Fprintln(conn, "HELLO")
+		expectLine(t, conn, s, "SEQ")
+		fmt.Fprintln(conn, "OK SEQ 0")
+		for _, cmd := range session {
+			expectLine(t, conn, s, cmd)
+		}
+		conn.Close()
+
+		conn, err = lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s = bufio.NewScanner(conn)
+		fmt.Fprintln(conn, "HELLO")
+		expectLine(t, conn, s, "SUB tick")
+		fmt.Fprintln(conn, "OK SUB buttons,approach,stop,door,tick")
+		expectLine(t, conn, s, "UNSUB door")
+		fmt.Fprintln(conn, "OK UNSUB buttons,approach,stop,tick")
+		expectLine(t, conn, s, "ROLE passenger")
+		fmt.Fprintln(conn, "ERR ROLE: passengers are not allowed")
+		expectLine(t, conn, s, "NAME bob")
+		fmt.Fprintln(conn, "OK NAME bob")
+		expectLine(t, conn, s, "RESUME 0")
+		fmt.Fprintln(conn, "OK RESUME 0")
+		expectLine(t, conn, s, "STATE")
+		fmt.Fprintln(conn, `STATE {"floor":1,"motor":"OFF","door":"CLOSED","panel":[],"up":[],"down":[]}`)
+		time.Sleep(time.Second)
+	}()
+
+	c, err := NewClient(WithAddr(lis.Addr().String()), WithReconnect(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, cmd := range session {
+		if err := c.Send(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, want := range []Kind{Reconnected, Rejected, StateReceived} {
+		evt, err := c.RecvEvent(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if evt.Kind != want {
+			t.Fatalf("expected %s, got %s", want, evt)
+		}
+	}
+}
+
+func TestClient_ReconnectClosed(t *testing.T) {
+	addr, conns := fakeServerSeq(t, "OK SEQ 0")
+	c, err := NewClient(WithAddr(addr), WithReconnect(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-conns
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Recv()
+		errc <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Recv didn't return after Close")
+	}
+}
+
+func TestClient_ByeTerminal(t *testing.T) {
+	addr, conns := fakeServerSeq(t, "OK SEQ 0")
+	c, err := NewClient(WithAddr(addr), WithReconnect(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	srv := <-conns
+	fmt.Fprintln(srv, "BYE kicked")
+	srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if evt, err := c.RecvContext(ctx); err != nil || evt != "BYE kicked" {
+		t.Fatalf("expected BYE, got %q (err=%v)", evt, err)
+	}
+
+	// No reconnect after a kick
+	if _, err := c.RecvContext(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected connection error, got %v", err)
+	}
+}
+
+func TestClient_CloseWhileDialing(t *testing.T) {
+	var (
+		servers = make(chan net.Conn, 2)
+		dialing = make(chan struct{})
+		release = make(chan struct{})
+		calls   int
+	)
+	dial := func(ctx context.Context) (net.Conn, error) {
+		calls++
+		client, server := net.Pipe()
+		servers <- server
+		if calls > 1 {
+			close(dialing)
+			<-release
+		}
+		return client, nil
+	}
+
+	c, err := NewClient(WithDialer(dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-servers
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Reconnect()
+	}()
+
+	<-dialing
+	c.Close()
+	close(release)
+
+	if err := <-errc; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	// The connection dialed after Close is closed
+	srv := <-servers
+	srv.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := srv.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestClient_State(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := <-conns
+
+	ctx := context.Background()
+	fmt.Fprint(srv, "P3 #1\n")
+	if _, err := c.RecvEvent(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SendCommand(ctx, MoveUp()); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := c.State().View(); !v.Panel[3] || v.Direction != DirectionUp {
+		t.Fatalf("bad state: %+v", v)
+	}
+
+	s := bufio.NewScanner(srv)
+	expectLine(t, srv, s, "MU")
+
+	go func() {
+		expectLine(t, srv, s, "SUB crash")
+		fmt.Fprint(srv, "OK SUB buttons,approach,stop,door,crash\n")
+		expectLine(t, srv, s, "STATE")
+		fmt.Fprint(srv, `STATE {"floor":2,"motor":"OFF","door":"OPEN","panel":[],"up":[],"down":[]}`+"\n")
+	}()
+
+	if err := c.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := c.State().View(); v.Floor != 2 || v.Door != DoorStateOpen || v.Panel[3] || v.Moving() {
+		t.Fatalf("bad state after sync: %+v", v)
+	}
+
+	// A rejected command is undone by a new snapshot
+	if err := c.Send("DC"); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, srv, s, "DC")
+
+	go func() {
+		fmt.Fprint(srv, "ERR DC: observer can't send commands\n")
+		expectLine(t, srv, s, "STATE")
+		fmt.Fprint(srv, `STATE {"floor":2,"motor":"OFF","door":"OPEN","panel":[],"up":[],"down":[]}`+"\n")
+		fmt.Fprint(srv, "RESET\n")
+	}()
+
+	if evt, err := c.RecvEvent(ctx); err != nil || evt.Kind != Rejected {
+		t.Fatalf("expected rejection, got %s (err=%v)", evt, err)
+	}
+
+	if v := c.State().View(); v.Door != DoorStateOpen {
+		t.Fatalf("bad state after rejection: %+v", v)
+	}
+
+	if evt, err := c.RecvEvent(ctx); err != nil || evt.Kind != Reset {
+		t.Fatalf("expected reset, got %s (err=%v)", evt, err)
+	}
+
+	if v := c.State().View(); v.Floor != 1 || v.Door != DoorStateClosed {
+		t.Fatalf("bad state after reset: %+v", v)
+	}
+}
+
+func TestClient_StateRejected(t *testing.T) {
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := <-conns
+	s := bufio.NewScanner(srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	go answerSync(t, srv, s, `{"floor":1,"motor":"OFF","door":"CLOSED","panel":[],"up":[],"down":[]}`)
+	if err := c.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Send("P2"); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, srv, s, "P2")
+
+	// The resync is rate limited as well
+	go func() {
+		fmt.Fprintln(srv, "ERR P2: rate limit exceeded")
+		expectLine(t, srv, s, "STATE")
+		fmt.Fprintln(srv, "ERR STATE: rate limit exceeded")
+		fmt.Fprintln(srv, "U3")
+		expectLine(t, srv, s, "STATE")
+		fmt.Fprintln(srv, `STATE {"floor":1,"motor":"OFF","door":"CLOSED","panel":[4],"up":[3],"down":[]}`)
+	}()
+
+	for _, want := range []Kind{Rejected, UpPressed} {
+		evt, err := c.RecvEvent(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if evt.Kind != want {
+			t.Fatalf("expected %s, got %s", want, evt)
+		}
+	}
+
+	// The second resync undid the rejected P2
+	if v := c.State().View(); v.Panel[2] || !v.Panel[4] {
+		t.Fatalf("bad state after resync: %+v", v)
+	}
+}
+
+// answerSync answers the Sync requests of a client with the snapshot state.
+func answerSync(t *testing.T, conn net.Conn, s *bufio.Scanner, state string) {
+	t.Helper()
+
+	expectLine(t, conn, s, "SUB crash")
+	fmt.Fprintln(conn, "OK SUB buttons,approach,stop,door,crash")
+	expectLine(t, conn, s, "STATE")
+	fmt.Fprintln(conn, "STATE "+state)
+}
+
+func TestClient_Guard(t *testing.T) {
+	addr, conns := fakeServer(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		srv := <-conns
+		s := bufio.NewScanner(srv)
+		// The car is at the top floor, MU would crash
+		answerSync(t, srv, s, `{"floor":4,"motor":"OFF","door":"CLOSED","panel":[],"up":[],"down":[]}`)
+		expectLine(t, srv, s, "MD")
+		expectLine(t, srv, s, "S")
+		expectLine(t, srv, s, "SUB tick")
+	}()
+
+	c, err := NewClient(WithAddr(addr), WithGuard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if err := c.SendCommand(ctx, MoveUp()); !errors.Is(err, ErrUnsafe) {
+		t.Fatalf("MU: expected ErrUnsafe, got %v", err)
+	}
+
+	if err := c.SendCommand(ctx, MoveDown()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []string{"DO", "XYZ", "U4", "P5", ""} {
+		if err := c.Send(cmd); !errors.Is(err, ErrUnsafe) {
+			t.Fatalf("%q: expected ErrUnsafe, got %v", cmd, err)
+		}
+	}
+
+	if err := c.SendCommand(ctx, Stop()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Send("SUB tick"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server timeout")
+	}
+}
+
+func TestClient_GuardOldSimulator(t *testing.T) {
+	addr, conns := oldServer(t)
+	c, err := NewClient(WithAddr(addr), WithGuard(), WithDialTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	srv := <-conns
+
+	// Older simulators crash on SUB and STATE, nothing is sent before the command
+	if err := c.Send("P2"); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, srv, bufio.NewScanner(srv), "P2")
+
+	if err := c.Sync(context.Background()); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected unsupported, got %v", err)
+	}
+
+	// The guard works from a reset elevator
+	if err := c.Send("MD"); !errors.Is(err, ErrUnsafe) {
+		t.Fatalf("expected unsafe, got %v", err)
+	}
+}
+
+func TestClient_GuardReconnect(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		s := bufio.NewScanner(conn)
+		fmt.Fprintln(conn, "HELLO")
+		expectLine(t, conn, s, "SEQ")
+		fmt.Fprintln(conn, "OK SEQ 0")
+		answerSync(t, conn, s, `{"floor":1,"motor":"OFF","door":"CLOSED","panel":[],"up":[],"down":[]}`)
+		conn.Close()
+
+		// The door was opened while the client was disconnected
+		conn, err = lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s = bufio.NewScanner(conn)
+		fmt.Fprintln(conn, "HELLO")
+		expectLine(t, conn, s, "SUB crash")
+		fmt.Fprintln(conn, "OK SUB buttons,approach,stop,door,crash")
+		expectLine(t, conn, s, "RESUME 0")
+		fmt.Fprintln(conn, "OK RESUME 0")
+		expectLine(t, conn, s, "STATE")
+		fmt.Fprintln(conn, `STATE {"floor":1,"motor":"OFF","door":"OPEN","panel":[],"up":[],"down":[]}`)
+		time.Sleep(time.Second)
+	}()
+
+	c, err := NewClient(WithAddr(lis.Addr().String()), WithGuard(), WithReconnect(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	for _, want := range []Kind{Reconnected, StateReceived} {
+		evt, err := c.RecvEvent(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if evt.Kind != want {
+			t.Fatalf("expected %s, got %s", want, evt)
+		}
+	}
+
+	if err := c.SendCommand(ctx, MoveUp()); !errors.Is(err, ErrUnsafe) {
+		t.Fatalf("expected ErrUnsafe with door open, got %v", err)
+	}
+}
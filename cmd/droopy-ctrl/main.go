@@ -0,0 +1,86 @@
+// droopy-ctrl runs a reference controller against the droopy simulator.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/353solutions/droopy"
+	"github.com/353solutions/droopy/controllers"
+)
+
+var options struct {
+	addr       string
+	algo       string
+	token      string
+	maxBackoff time.Duration
+	guard      bool
+}
+
+func main() {
+	algos := strings.Join(controllers.Algorithms(), ", ")
+	flag.StringVar(&options.addr, "addr", "localhost:10000", "simulator address")
+	flag.StringVar(&options.algo, "algo", "look", fmt.Sprintf("dispatch algorithm (%s)", algos))
+	flag.StringVar(&options.token, "token", "", "simulator authentication token")
+	flag.DurationVar(&options.maxBackoff, "max-backoff", 5*time.Second, "maximal time between reconnect attempts")
+	flag.BoolVar(&options.guard, "guard", true, "refuse commands that would crash the elevator")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options]\n", path.Base(os.Args[0]))
+		fmt.Println("Options:")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	ctrl, err := controllers.New(options.algo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	opts := []droopy.ClientOption{
+		droopy.WithAddr(options.addr),
+		droopy.WithReconnect(options.maxBackoff),
+	}
+	if options.token != "" {
+		opts = append(opts, droopy.WithToken(options.token))
+	}
+	if options.guard {
+		opts = append(opts, droopy.WithGuard())
+	}
+
+	c, err := droopy.NewClient(opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	// Make sure passengers can't move the car under us
+	if err := c.SendContext(ctx, "LOCK"); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("running %s controller on %s\n", options.algo, options.addr)
+	err = droopy.Run(ctx, c, ctrl)
+	switch {
+	case errors.Is(err, droopy.ErrBye):
+		// The simulator kicked us, the client reconnects after a shutdown
+		fmt.Println(err)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/353solutions/droopy/sim"
+)
+
+const maxSpeed = 100
+
+// console handles operator commands (":kick team-blue") from stdin.
+// Operator commands don't go through Elevator.Handle, mistakes are reported and never crash the elevator.
+type console struct {
+	paused bool
+	speed  float64 // simulation speed, 1 is real time
+	ticks  float64 // accumulated fractional ticks
+}
+
+func newConsole() *console {
+	return &console{speed: 1}
+}
+
+// isAdminCmd reports if line is an operator command.
+func isAdminCmd(line string) bool {
+	return strings.HasPrefix(line, ":")
+}
+
+// Ticks returns how many ticks to run for a single ticker tick, according to the speed.
+func (c *console) Ticks() int {
+	if c.paused {
+		return 0
+	}
+
+	c.ticks += c.speed
+	n := int(c.ticks)
+	c.ticks -= float64(n)
+	return n
+}
+
+// Handle handles an operator command on s, it returns the output to print.
+func (c *console) Handle(line string, s *sim.Sim) string {
+	fields := strings.Fields(strings.TrimPrefix(line, ":"))
+	if len(fields) == 0 {
+		return "error: missing command\n"
+	}
+
+	out, err := c.handle(fields[0], fields[1:], s)
+	if err != nil {
+		return fmt.Sprintf("error: %s: %s\n", fields[0], err)
+	}
+
+	return out
+}
+
+// adminArgs is the number of arguments of every operator command.
+var adminArgs = map[string]int{
+	"conns":  0,
+	"kick":   1,
+	"pause":  0,
+	"resume": 0,
+	"speed":  1,
+	"stats":  0,
+	"save":   1,
+	"fault":  1,
+}
+
+func (c *console) handle(cmd string, args []string, s *sim.Sim) (string, error) {
+	n, ok := adminArgs[cmd]
+	if !ok {
+		return "", fmt.Errorf("unknown command (try H)")
+	}
+
+	if len(args) != n {
+		return "", fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+
+	switch cmd {
+	case "conns":
+		return connsTable(s.Conns(), time.Now()), nil
+	case "kick":
+		if err := s.Kick(args[0], "BYE kicked", time.Second); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("kicked %s\n", args[0]), nil
+	case "pause":
+		c.paused = true
+		return "paused\n", nil
+	case "resume":
+		c.paused = false
+		return "resumed\n", nil
+	case "speed":
+		speed, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || speed <= 0 || speed > maxSpeed {
+			return "", fmt.Errorf("%q: not a number in (0, %d]", args[0], maxSpeed)
+		}
+		c.speed, c.ticks = speed, 0
+		return fmt.Sprintf("speed %g\n", speed), nil
+	case "stats":
+		return report(s.Stats()) + "\n", nil
+	case "save":
+		data, err := json.MarshalIndent(s.State(), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(args[0], append(data, '\n'), 0o644); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("saved state to %s\n", args[0]), nil
+	case "fault":
+		if args[0] == "clear" {
+			s.SetFaults(0)
+			return "faults cleared\n", nil
+		}
+
+		f, err := sim.ParseFault(args[0])
+		if err != nil {
+			return "", err
+		}
+		s.SetFaults(s.Faults() | f)
+		return fmt.Sprintf("fault %s\n", args[0]), nil
+	}
+
+	return "", nil
+}
+
+// connsTable formats conns as a table, connection time is shown relative to now.
+func connsTable(conns []sim.ConnInfo, now time.Time) string {
+	if len(conns) == 0 {
+		return "no connections\n"
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROLE\tADDRESS\tCONNECTED\tCOMMANDS\tEVENTS")
+	for _, c := range conns {
+		name := c.Name
+		if name == "" {
+			name = "-"
+		}
+		age := now.Sub(c.Connected).Round(time.Second)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", name, c.Role, c.Addr, age, c.Commands, c.Events)
+	}
+	w.Flush()
+
+	return buf.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/353solutions/droopy/sim"
+)
+
+func TestConnsTable(t *testing.T) {
+	now := time.Now()
+	conns := []sim.ConnInfo{
+		{Addr: "127.0.0.1:4321", Name: "team-blue", Role: "controller", Connected: now.Add(-time.Minute), Commands: 7, Events: 3},
+		{Addr: "pipe", Role: "observer", Connected: now},
+	}
+
+	out := connsTable(conns, now)
+	for _, s := range []string{"team-blue", "controller", "127.0.0.1:4321", "1m0s", "observer"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("%q not in:\n%s", s, out)
+		}
+	}
+}
+
+func TestConsole_Ticks(t *testing.T) {
+	c := newConsole()
+	if n := c.Ticks(); n != 1 {
+		t.Fatalf("speed 1: expected 1 tick, got %d", n)
+	}
+
+	c.Handle(":speed 0.5", nil)
+	total := 0
+	for range 4 {
+		total += c.Ticks()
+	}
+	if total != 2 {
+		t.Fatalf("speed 0.5: expected 2 ticks, got %d", total)
+	}
+
+	c.Handle(":speed 3", nil)
+	if n := c.Ticks(); n != 3 {
+		t.Fatalf("speed 3: expected 3 ticks, got %d", n)
+	}
+
+	c.Handle(":pause", nil)
+	if n := c.Ticks(); n != 0 {
+		t.Fatalf("paused: expected no ticks, got %d", n)
+	}
+
+	c.Handle(":resume", nil)
+	if n := c.Ticks(); n != 3 {
+		t.Fatalf("resumed: expected 3 ticks, got %d", n)
+	}
+}
+
+// newTestSim returns a simulator that's closed when the test ends.
+func newTestSim(t *testing.T) *sim.Sim {
+	t.Helper()
+
+	s := sim.New(sim.Options{})
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestConsole_Errors(t *testing.T) {
+	s := newTestSim(t)
+	c := newConsole()
+
+	for _, line := range []string{":", ":jump", ":speed", ":speed fast", ":speed -1", ":kick nobody", ":fault fire"} {
+		out := c.Handle(line, s)
+		if !strings.HasPrefix(out, "error: ") {
+			t.Errorf("%q: expected error, got %q", line, out)
+		}
+	}
+
+	if s.State().Crashed || s.Crashes() != 0 {
+		t.Fatal("console error crashed the elevator")
+	}
+}
+
+func TestConsole_Fault(t *testing.T) {
+	s := newTestSim(t)
+	c := newConsole()
+
+	c.Handle(":fault door-jam", s)
+	s.Handle("DO")
+	for range sim.TicksPerDoor * 2 {
+		if evt := s.Step(); evt != "" {
+			t.Fatalf("jammed door: got %q", evt)
+		}
+	}
+
+	c.Handle(":fault clear", s)
+	if evt := s.Step(); evt != "O1" {
+		t.Fatalf("expected O1, got %q", evt)
+	}
+}
+
+func TestConsole_Save(t *testing.T) {
+	s := newTestSim(t)
+	s.Handle("P3")
+	c := newConsole()
+
+	file := filepath.Join(t.TempDir(), "state.json")
+	if out := c.Handle(":save "+file, s); strings.HasPrefix(out, "error") {
+		t.Fatal(out)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var st sim.State
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Floor != 1 || len(st.Panel) != 1 || st.Panel[0] != 3 {
+		t.Fatalf("bad state: %+v", st)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/353solutions/droopy/sim"
+)
+
+// EventHub fans out events to Server-Sent Events subscribers.
+type EventHub struct {
+	mu     sync.Mutex
+	subs   map[chan string]struct{}
+	closed bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subs: make(map[chan string]struct{}),
+	}
+}
+
+// Subscribe returns a channel of events, it's closed by Close.
+func (h *EventHub) Subscribe() chan string {
+	ch := make(chan string, 16)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return ch
+	}
+
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+func (h *EventHub) Unsubscribe(ch chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+// Publish sends evt to all subscribers, slow subscribers miss the event.
+func (h *EventHub) Publish(evt string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- evt:
+		default:
+			debug("sse: subscriber too slow, dropping %s\n", evt)
+		}
+	}
+}
+
+// Feed publishes the events from ch until it's closed.
+func (h *EventHub) Feed(ch <-chan string) {
+	for evt := range ch {
+		h.Publish(evt)
+	}
+}
+
+// Close sends msg (e.g. BYE) to all subscribers and closes their channels.
+// Unlike Publish, msg is never dropped: the oldest event makes room for it.
+func (h *EventHub) Close(msg string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+
+	for ch := range h.subs {
+		if len(ch) == cap(ch) {
+			<-ch
+		}
+		ch <- msg
+		close(ch)
+		delete(h.subs, ch)
+	}
+}
+
+type API struct {
+	s   *sim.Sim
+	hub *EventHub
+}
+
+// newAPI returns the HTTP API handler for s, if token is not empty requests must have it as a bearer token.
+func newAPI(s *sim.Sim, hub *EventHub, token string) http.Handler {
+	api := API{s: s, hub: hub}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /state", api.stateHandler)
+	mux.HandleFunc("GET /stats", api.statsHandler)
+	mux.HandleFunc("GET /conns", api.connsHandler)
+	mux.HandleFunc("POST /buttons/{button}", api.buttonHandler)
+	mux.HandleFunc("POST /reset", api.resetHandler)
+	mux.HandleFunc("GET /events", api.eventsHandler)
+	return requireToken(token, mux)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		debug("http: can't encode - %s\n", err)
+	}
+}
+
+func (a *API) stateHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.s.State())
+}
+
+func (a *API) statsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.s.Stats())
+}
+
+func (a *API) connsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.s.Conns())
+}
+
+type actionReply struct {
+	Event string    `json:"event,omitempty"`
+	State sim.State `json:"state"`
+}
+
+func (a *API) buttonHandler(w http.ResponseWriter, r *http.Request) {
+	button := r.PathValue("button")
+	if !sim.IsButton(button) {
+		http.Error(w, fmt.Sprintf("unknown button: %q", button), http.StatusBadRequest)
+		return
+	}
+
+	evt := a.s.Handle(button)
+	writeJSON(w, actionReply{evt, a.s.State()})
+}
+
+func (a *API) resetHandler(w http.ResponseWriter, r *http.Request) {
+	evt := a.s.Handle("R")
+	writeJSON(w, actionReply{evt, a.s.State()})
+}
+
+func (a *API) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := a.hub.Subscribe()
+	defer a.hub.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	for {
+		select {
+		case evt, ok := <-ch:
+			// The hub is closed on shutdown, after sending BYE
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", evt)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// httpListener serves the HTTP API on lis, over TLS if srv.TLSConfig is set.
+func httpListener(srv *http.Server, lis net.Listener) {
+	var err error
+	if srv.TLSConfig != nil {
+		// The certificates are in srv.TLSConfig
+		err = srv.ServeTLS(lis, "", "")
+	} else {
+		err = srv.Serve(lis)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		warn("http: %s", err)
+	}
+}
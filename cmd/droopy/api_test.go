@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/353solutions/droopy/sim"
+)
+
+func newTestAPI(t *testing.T) (*httptest.Server, *sim.Sim, *EventHub) {
+	t.Helper()
+
+	s := newTestSim(t)
+	hub := NewEventHub()
+	go hub.Feed(s.Events(context.Background()))
+
+	srv := httptest.NewServer(newAPI(s, hub, ""))
+	t.Cleanup(srv.Close)
+
+	return srv, s, hub
+}
+
+func TestAPI_State(t *testing.T) {
+	srv, _, _ := newTestAPI(t)
+
+	resp, err := http.Get(srv.URL + "/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad status: %d", resp.StatusCode)
+	}
+
+	var s sim.State
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Floor != 1 || s.Motor != "OFF" || s.Door != "CLOSED" {
+		t.Fatalf("bad state: %+v", s)
+	}
+}
+
+func TestAPI_Button(t *testing.T) {
+	srv, _, _ := newTestAPI(t)
+
+	resp, err := http.Post(srv.URL+"/buttons/P3", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad status: %d", resp.StatusCode)
+	}
+
+	var reply actionReply
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Event != "P3" {
+		t.Fatalf("expected P3 event, got %q", reply.Event)
+	}
+
+	if len(reply.State.Panel) != 1 || reply.State.Panel[0] != 3 {
+		t.Fatalf("bad panel: %v", reply.State.Panel)
+	}
+
+	resp, err = http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var stats sim.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Commands != 1 || stats.Events != 1 {
+		t.Fatalf("bad stats: %+v", stats)
+	}
+}
+
+func TestAPI_BadButton(t *testing.T) {
+	srv, _, _ := newTestAPI(t)
+
+	for _, button := range []string{"MU", "Q", "U4", "D1"} {
+		t.Run(button, func(t *testing.T) {
+			resp, err := http.Post(srv.URL+"/buttons/"+button, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected bad request, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestAPI_Conns(t *testing.T) {
+	srv, s, _ := newTestAPI(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	go fmt.Fprintln(client, "NAME team-blue")
+	sc := bufio.NewScanner(client)
+	for _, want := range []string{sim.Greeting, "OK NAME team-blue"} {
+		if !sc.Scan() || sc.Text() != want {
+			t.Fatalf("expected %q, got %q (err=%v)", want, sc.Text(), sc.Err())
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/conns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var conns []sim.ConnInfo
+	if err := json.NewDecoder(resp.Body).Decode(&conns); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conns) != 1 || conns[0].Name != "team-blue" || conns[0].Role != "auto" {
+		t.Fatalf("bad conns: %+v", conns)
+	}
+}
+
+func TestAPI_Events(t *testing.T) {
+	srv, _, hub := newTestAPI(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("bad content type: %q", ct)
+	}
+
+	// Subscription is done before the headers are flushed
+	resp2, err := http.Post(srv.URL+"/buttons/U2", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+
+	s := bufio.NewScanner(resp.Body)
+	if !s.Scan() {
+		t.Fatalf("no event: %v", s.Err())
+	}
+
+	if line := s.Text(); line != "data: U2" {
+		t.Fatalf("bad event line: %q", line)
+	}
+
+	hub.Close("BYE quit")
+	var lines []string
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) != 1 || lines[0] != "data: BYE quit" {
+		t.Fatalf("expected BYE and end of stream, got %q (err=%v)", lines, s.Err())
+	}
+}
+
+func TestEventHub_Close(t *testing.T) {
+	hub := NewEventHub()
+	ch := hub.Subscribe()
+	for range cap(ch) + 1 {
+		hub.Publish("T")
+	}
+
+	hub.Close("BYE quit")
+	var last string
+	for evt := range ch {
+		last = evt
+	}
+
+	if last != "BYE quit" {
+		t.Fatalf("expected BYE last, got %q", last)
+	}
+
+	if _, ok := <-hub.Subscribe(); ok {
+		t.Fatal("subscribed to a closed hub")
+	}
+}
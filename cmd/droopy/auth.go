@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// readToken loads the token from the -token or -token-file options.
+func readToken(token, file string) (string, error) {
+	if token != "" && file != "" {
+		return "", errors.New("can't use both -token and -token-file")
+	}
+
+	if file == "" {
+		return token, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	token = strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s: empty token", file)
+	}
+
+	return token, nil
+}
+
+// requireToken wraps h so requests must have an "Authorization: Bearer <token>" header.
+// h is returned as is if token is empty.
+func requireToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arg, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(arg), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "bad or missing token", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
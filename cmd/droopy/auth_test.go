@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := requireToken("s3cr3t", ok)
+
+	cases := []struct {
+		header string
+		status int
+	}{
+		{"Bearer s3cr3t", http.StatusOK},
+		{"Bearer guess", http.StatusUnauthorized},
+		{"s3cr3t", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/reset", nil)
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/353solutions/droopy/sim"
+)
+
+// loop is the simulator main loop, it drives the simulator from the console.
+// Clients and the HTTP API talk to the simulator directly, the loop redraws the status when they change it.
+type loop struct {
+	s       *sim.Sim
+	console *console
+}
+
+func newLoop(s *sim.Sim) *loop {
+	return &loop{s: s, console: newConsole()}
+}
+
+// run handles messages from ch until "Q", "EOF" or ch is closed, it returns the shutdown reason.
+// The elevator status is printed to out when it changes.
+func (l *loop) run(ch <-chan Message, out io.Writer) string {
+	lastState := statusLine(l.s)
+	fmt.Fprint(out, lastState)
+
+	for msg := range ch {
+		if msg.Payload != "T" {
+			debug("%-5s: %s\n", msg.Origin, msg.Payload)
+		}
+
+		if msg.Payload == "EOF" || msg.Payload == "Q" {
+			return shutdownReason(msg)
+		}
+
+		evt := l.handle(msg, out)
+
+		state := statusLine(l.s)
+		if state != lastState || msg.Origin == "stdin" {
+			if msg.Origin != "stdin" {
+				fmt.Fprintln(out)
+			}
+			if reason, ok := strings.CutPrefix(evt, "CRASH "); ok {
+				fmt.Fprintf(out, "\033[31mcrash: %s\033[39m\n", reason)
+			}
+			fmt.Fprint(out, state)
+			lastState = state
+		}
+	}
+
+	return "quit"
+}
+
+// handle handles a single message, it returns the elevator event (empty if none).
+// Console output (help, operator commands) goes to out.
+func (l *loop) handle(msg Message, out io.Writer) string {
+	switch {
+	case msg.Origin == "ctrl":
+		// Clients change the simulator directly, the payload is the event of their command
+		return msg.Payload
+	case msg.Origin != "stdin" && msg.Origin != "ticker":
+		// Heartbeats change the simulator directly, we only redraw
+		return ""
+	case msg.Payload == "":
+		// Ignore user hitting Enter
+		return ""
+	case msg.Payload == "H":
+		fmt.Fprintln(out, help)
+		return ""
+	case msg.Origin == "stdin" && isAdminCmd(msg.Payload):
+		fmt.Fprint(out, l.console.Handle(msg.Payload, l.s))
+		return ""
+	case msg.Origin == "stdin":
+		return l.s.Handle(msg.Payload)
+	}
+
+	// The console speed decides how many ticks a ticker tick is
+	var evt string
+	for range l.console.Ticks() {
+		evt = l.s.Step()
+		if strings.HasPrefix(evt, "CRASH ") {
+			break
+		}
+	}
+
+	return evt
+}
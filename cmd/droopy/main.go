@@ -2,385 +2,88 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"context"
+	"crypto/tls"
 	_ "embed"
-	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"strings"
 	"time"
-)
-
-var pool *ConnPool
-
-func sockListener(addr string, ch chan<- Message) {
-	lis, err := net.Listen("tcp", addr)
-	if err != nil {
-		panic(err)
-	}
-
-	for {
-		conn, err := lis.Accept()
-		if err != nil {
-			panic(err)
-		}
-		pool.Add(conn)
-		go handler(conn, ch)
-	}
-}
-
-func handler(conn net.Conn, ch chan<- Message) {
-	defer pool.Remove(conn)
-	defer conn.Close()
-
-	s := bufio.NewScanner(conn)
-	for s.Scan() {
-		ch <- Message{"ctrl", s.Text()}
-	}
 
-	if err := s.Err(); err != nil && !errors.Is(err, io.EOF) {
-		panic(err)
-	}
-}
-
-func sendEvent(msg string) {
-	pool.Broadcast(msg)
-}
+	"github.com/353solutions/droopy/sim"
+)
 
 func stdinListener(ch chan<- Message) {
 	s := bufio.NewScanner(os.Stdin)
 	for s.Scan() {
-		ch <- Message{"stdin", s.Text()}
+		ch <- Message{Origin: "stdin", Payload: s.Text()}
 	}
 
 	if err := s.Err(); err != nil {
 		panic(err)
 	}
 
-	ch <- Message{"stdin", "EOF"}
-}
-
-func ticker(ch chan<- Message) {
-	for range time.Tick(100 * time.Millisecond) {
-		ch <- Message{"ticker", "T"}
-	}
-}
-
-type Message struct {
-	Origin  string
-	Payload string
-}
-
-const MaxFloor = 4
-
-type MotorState byte
-
-const (
-	MotorUp MotorState = iota + 1
-	MotorDown
-	MotorOff
-)
-
-func (s MotorState) String() string {
-	switch s {
-	case MotorUp:
-		return "UP"
-	case MotorDown:
-		return "DOWN"
-	case MotorOff:
-		return "OFF"
-	}
-
-	return fmt.Sprintf("MotorState(%d)", s)
-}
-
-type DoorState byte
-
-const (
-	DoorOpening DoorState = iota + 1
-	DoorOpen
-	DoorClosing
-	DoorClosed
-)
-
-func (s DoorState) String() string {
-	switch s {
-	case DoorOpening:
-		return "OPENING"
-	case DoorOpen:
-		return "OPEN"
-	case DoorClosing:
-		return "CLOSING"
-	case DoorClosed:
-		return "CLOSED"
-	}
-
-	return fmt.Sprintf("DoorState(%d)", s)
-}
-
-type Elevator struct {
-	// floors start at 1
-	panel      [MaxFloor + 1]bool // in car panel
-	up         [MaxFloor + 1]bool // up buttons on floors
-	down       [MaxFloor + 1]bool // down buttons on floors
-	floor      int                // Current floor, starts at 1
-	motor      MotorState
-	door       DoorState
-	stopping   bool
-	crashed    bool
-	crashCount int // Total crashes this session
-	eventTime  int // Start of event such as door opening, move ...
-}
-
-func (e *Elevator) crash() {
-	if !e.crashed {
-		e.crashed = true
-		e.crashCount++
-	}
-}
-
-func (e *Elevator) Reset() {
-	for i := range e.panel {
-		e.panel[i] = false
-	}
-
-	for i := range e.up {
-		e.up[i] = false
-	}
-
-	for i := range e.down {
-		e.down[i] = false
-	}
-
-	e.floor = 1
-	e.motor = MotorOff
-	e.door = DoorClosed
-	e.crashed = false
-}
-
-const (
-	ticksPerFloor = 40
-	ticksPerDoor  = 20
-	approachTicks = 10
-)
-
-// setDoor sets door state, returns crash message.
-func (e *Elevator) setDoor(state DoorState) string {
-	switch {
-	case e.motor != MotorOff:
-		e.crash()
-		return "crash: door command while moving"
-	case e.door == DoorClosed && state == DoorOpening:
-		e.door = DoorOpening
-		e.eventTime = 0
-		return ""
-	case e.door == DoorOpen && state == DoorClosing:
-		e.door = DoorClosing
-		e.eventTime = 0
-		return ""
-	}
-
-	e.crash()
-	return fmt.Sprintf("crash: door %s in state %s", state, e.door)
-}
-
-// setMotor sets motor state, returns crash message.
-func (e *Elevator) setMotor(state MotorState) string {
-	if e.door != DoorClosed {
-		e.crash()
-		return fmt.Sprintf("crash: motor command while door %s", e.door)
-	}
-
-	if e.motor != MotorOff {
-		e.crash()
-		return fmt.Sprintln("crash: motor command while moving")
-	}
-
-	if e.motor == MotorOff && state == MotorOff {
-		e.crash()
-		return "crash: motor already off"
-	}
-
-	e.motor = state
-	e.eventTime = 0
-	return ""
-}
-
-func nextFloor(floor int, motor MotorState) int {
-	if motor == MotorUp {
-		return floor + 1
-	}
-
-	return floor - 1
-}
-
-func cmdFloor(cmd string) int {
-	return int(cmd[len(cmd)-1] - '0')
+	ch <- Message{Origin: "stdin", Payload: "EOF"}
 }
 
-// Handle handles a command, returns an event to report (empty string if no event).
-func (e *Elevator) Handle(cmd string) string {
-	if cmd == "R" { // Reset
-		e.Reset()
-		return ""
-	}
-
-	// Ignore commands when crashed
-	if e.crashed {
-		return ""
-	}
-
-	switch cmd {
-	case "P1", "P2", "P3", "P4":
-		e.panel[cmdFloor(cmd)] = true
-		return cmd
-	case "CP1", "CP2", "CP3", "CP4":
-		e.panel[cmdFloor(cmd)] = false
-		return cmd
-	case "U1", "U2", "U3":
-		e.up[cmdFloor(cmd)] = true
-		return cmd
-	case "CU1", "CU2", "CU3":
-		e.up[cmdFloor(cmd)] = false
-		return cmd
-	case "D2", "D3", "D4":
-		e.down[cmdFloor(cmd)] = true
-		return cmd
-	case "CD2", "CD3", "CD4":
-		e.down[cmdFloor(cmd)] = false
-		return cmd
-	case "DO":
-		return e.setDoor(DoorOpening)
-	case "DC":
-		return e.setDoor(DoorClosing)
-	case "MU":
-		return e.setMotor(MotorUp)
-	case "MD":
-		return e.setMotor(MotorDown)
-	case "S":
-		if e.stopping {
-			e.crash()
-			return "crash: already stopping"
-		}
-
-		if e.motor == MotorOff {
-			e.crash()
-			return "crash: not moving"
-		}
-
-		e.stopping = true
-	case "T":
-		e.eventTime++
-
-		if e.door == DoorOpening || e.door == DoorClosing {
-			if e.eventTime <= ticksPerDoor {
-				return ""
-			}
+// heartbeat sends heartbeats every interval until done is closed,
+// it notifies the main loop when the controller health changes.
+func heartbeat(s *sim.Sim, interval time.Duration, ch chan<- Message, done <-chan struct{}) {
+	healthy := true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-			var evt string
-			if e.door == DoorOpening {
-				e.door = DoorOpen
-				evt = "O"
-			} else {
-				e.door = DoorClosed
-				evt = "C"
-			}
-			e.eventTime = 0
-			return fmt.Sprintf("%s%d", evt, e.floor)
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
 		}
 
-		if e.motor == MotorUp || e.motor == MotorDown {
-			if e.eventTime == ticksPerFloor {
-				floor := nextFloor(e.floor, e.motor)
-				if floor > MaxFloor {
-					e.crash()
-					return "crash: out of the roof"
-				}
-
-				if floor < 1 {
-					e.crash()
-					return "crash: into the basement"
-				}
-
-				e.floor = floor
-				e.eventTime = 0
-
-				if e.stopping {
-					e.stopping = false
-					e.motor = MotorOff
-					return fmt.Sprintf("S%d", e.floor)
-				}
-			}
-
-			if e.eventTime == ticksPerFloor-approachTicks {
-				floor := nextFloor(e.floor, e.motor)
-				if floor >= 1 && floor <= MaxFloor {
-					return fmt.Sprintf("A%d", floor)
-				}
+		s.Heartbeat()
+		if h := !s.UnhealthyController(); h != healthy {
+			healthy = h
+			select {
+			case ch <- Message{Origin: "heartbeat"}:
+			case <-done:
+				return
 			}
 		}
-	default:
-		e.crash()
-		return fmt.Sprintf("crash: unknown command - %q", cmd)
 	}
-
-	return ""
 }
 
-func (e *Elevator) statusStr() string {
-	if e.crashed {
-		return "CRASH"
-	}
-
-	if e.stopping {
-		return "STOPPING"
-	}
-
-	if e.motor == MotorUp || e.motor == MotorDown {
-		return e.motor.String()
-	}
-
-	if e.door == DoorClosed || e.door == DoorClosing || e.door == DoorOpen || e.door == DoorOpening {
-		return e.door.String()
+func ticker(ch chan<- Message) {
+	for range time.Tick(100 * time.Millisecond) {
+		ch <- Message{Origin: "ticker", Payload: "T"}
 	}
-
-	panic(fmt.Sprintf("unknown state: %#v", e))
 }
 
-func buttonsStr(buttons []bool) string {
-	buf := make([]byte, len(buttons)-1) // 0 is a placeholder
-	for i, v := range buttons[1:] {
-		if v {
-			buf[i] = '0' + byte(i+1)
-		} else {
-			buf[i] = '-'
-		}
-	}
-
-	return string(buf)
+type Message struct {
+	Origin  string
+	Payload string
 }
 
-func (e *Elevator) String() string {
-	var buf bytes.Buffer
-	count := pool.Len()
+// statusLine returns the elevator status line, with a connection marker.
+func statusLine(s *sim.Sim) string {
 	conn := " "
-	if count > 0 {
+	switch {
+	case s.UnhealthyController():
+		conn = "!"
+	case len(s.Conns()) > 0:
 		conn = "*"
 	}
 
-	fmt.Fprintf(&buf, "[%sFLOOR %d", conn, e.floor)
-	fmt.Fprintf(&buf, "| %-8s", e.statusStr())
-	fmt.Fprintf(&buf, "| P:%s", buttonsStr(e.panel[:]))
-	fmt.Fprintf(&buf, "| U:%s", buttonsStr(e.up[:]))
-	fmt.Fprintf(&buf, "| D:%s", buttonsStr(e.down[:]))
-	fmt.Fprintf(&buf, " ] : ")
+	return fmt.Sprintf("[%s%s ] : ", conn, s)
+}
 
-	return buf.String()
+// warn prints a warning to stderr.
+func warn(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "\nwarning: "+format+"\n", args...)
 }
 
 func debug(format string, args ...any) {
@@ -395,7 +98,7 @@ func sigHandler(ch chan<- Message) {
 	sch := make(chan os.Signal, 1)
 	signal.Notify(sch, os.Interrupt)
 	<-sch
-	ch <- Message{"signal", "Q"}
+	ch <- Message{Origin: "signal", Payload: "Q"}
 }
 
 var (
@@ -410,6 +113,18 @@ func validateAddr(addr string) error {
 	return err
 }
 
+// listFlag is a flag that can be repeated.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
 func farewellMessage(crashCount int) string {
 	switch {
 	case crashCount == 0:
@@ -436,9 +151,29 @@ func farewellMessage(crashCount int) string {
 }
 
 var options struct {
-	addr    string
-	version bool
-	play    bool
+	addr            string
+	listen          listFlag
+	httpAddr        string
+	queueSize       int
+	slowPolicy      string
+	history         int
+	heartbeat       time.Duration
+	hbTimeout       time.Duration
+	failsafe        bool
+	tlsCert         string
+	tlsKey          string
+	tlsCA           string
+	tlsServerCA     string
+	token           string
+	tokenFile       string
+	authTimeout     time.Duration
+	shutdownTimeout time.Duration
+	maxLine         int
+	rate            float64
+	burst           int
+	rateDisconnect  time.Duration
+	version         bool
+	play            bool
 }
 
 var playHelp = `play commands from standard input. 
@@ -451,7 +186,27 @@ Empty lines and lines starting with # are ignored.`
 
 func main() {
 	flag.BoolVar(&options.version, "version", false, "show version and exit")
-	flag.StringVar(&options.addr, "addr", ":10000", "simulator address")
+	flag.StringVar(&options.addr, "addr", ":10000", "simulator address (e.g. :10000 or unix:/tmp/droopy.sock)")
+	flag.Var(&options.listen, "listen", "additional listener address, can be repeated (e.g. tcp://localhost:10001?role=observer)")
+	flag.StringVar(&options.httpAddr, "http", "", "HTTP API address (disabled if empty)")
+	flag.IntVar(&options.queueSize, "queue-size", 64, "outbound event queue size per connection")
+	flag.StringVar(&options.slowPolicy, "slow-policy", "block", "what to do when a connection queue is full (block, drop-oldest, disconnect)")
+	flag.IntVar(&options.history, "history", 1024, "number of events to keep for RESUME")
+	flag.DurationVar(&options.heartbeat, "heartbeat", 0, "heartbeat (PING) interval (disabled if 0)")
+	flag.DurationVar(&options.hbTimeout, "heartbeat-timeout", 2*time.Second, "time to wait for PONG")
+	flag.BoolVar(&options.failsafe, "failsafe", false, "stop at the next floor when the controller misses a heartbeat")
+	flag.StringVar(&options.tlsCert, "tls-cert", "", "TLS certificate file (TLS is disabled if empty)")
+	flag.StringVar(&options.tlsKey, "tls-key", "", "TLS key file")
+	flag.StringVar(&options.tlsCA, "tls-client-ca", "", "CA file for client certificates, clients must present a certificate signed by it (client certificates are not used if empty)")
+	flag.StringVar(&options.tlsServerCA, "tls-ca", "", "CA file for the simulator certificate with -play (system CAs if empty), -tls-cert and -tls-key are the client certificate")
+	flag.StringVar(&options.token, "token", "", "connections must send AUTH <token> first (no authentication if empty)")
+	flag.StringVar(&options.tokenFile, "token-file", "", "file with the authentication token")
+	flag.DurationVar(&options.authTimeout, "auth-timeout", 5*time.Second, "time to wait for AUTH")
+	flag.DurationVar(&options.shutdownTimeout, "shutdown-timeout", 3*time.Second, "time to wait for clients on shutdown")
+	flag.IntVar(&options.maxLine, "max-line", 1024, "maximal command line length in bytes")
+	flag.Float64Var(&options.rate, "rate", 100, "maximal commands per second per connection (no limit if 0)")
+	flag.IntVar(&options.burst, "burst", 100, "command burst size for -rate")
+	flag.DurationVar(&options.rateDisconnect, "rate-disconnect", 5*time.Second, "disconnect connections exceeding -rate for this long")
 	flag.BoolVar(&options.play, "play", false, playHelp)
 
 	flag.Usage = func() {
@@ -467,79 +222,149 @@ func main() {
 		os.Exit(0)
 	}
 
-	if err := validateAddr(options.addr); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	addrs := options.listen
+	if options.addr != "" {
+		addrs = append([]string{options.addr}, addrs...)
+	}
+
+	if len(addrs) == 0 {
+		fmt.Fprintln(os.Stderr, "error: no address to listen on")
 		os.Exit(1)
 	}
 
-	if options.play {
-		if err := playCmd(options.addr); err != nil {
+	if options.httpAddr != "" {
+		if err := validateAddr(options.httpAddr); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %s\n", err)
 			os.Exit(1)
 		}
-
-		return
 	}
 
-	pool = NewConnPool()
+	policy, err := sim.ParseSlowPolicy(options.slowPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
 
-	debug("address: %s\n", options.addr)
+	if options.queueSize < 1 {
+		fmt.Fprintf(os.Stderr, "error: bad queue size: %d\n", options.queueSize)
+		os.Exit(1)
+	}
 
-	ch := make(chan Message)
+	if options.history < 0 {
+		fmt.Fprintf(os.Stderr, "error: bad history size: %d\n", options.history)
+		os.Exit(1)
+	}
 
-	go sockListener(options.addr, ch)
-	go stdinListener(ch)
-	go sigHandler(ch)
-	go ticker(ch)
+	options.token, err = readToken(options.token, options.tokenFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
 
-	var e Elevator
-	e.Reset()
-	defer func() {
-		fmt.Println()
-		fmt.Println(farewellMessage(e.crashCount))
-	}()
-
-	lastState := e.String()
-	fmt.Print(lastState)
-	for msg := range ch {
-		if msg.Payload != "T" {
-			debug("%-5s: %s\n", msg.Origin, msg.Payload)
+	if options.play {
+		// -tls-cert and -tls-key are the client certificate
+		var tlsConfig *tls.Config
+		if options.tlsCert != "" || options.tlsKey != "" || options.tlsServerCA != "" {
+			tlsConfig, err = loadClientTLS(options.tlsCert, options.tlsKey, options.tlsServerCA, options.addr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				os.Exit(1)
+			}
 		}
 
-		if msg.Payload == "EOF" || msg.Payload == "Q" {
-			return
+		if err := playCmd(options.addr, options.token, tlsConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
 		}
 
-		if msg.Origin == "ctrl" {
-			debug("c:%s", msg.Payload)
+		return
+	}
+
+	var tlsConfig *tls.Config
+	if options.tlsCert != "" || options.tlsKey != "" {
+		tlsConfig, err = loadTLS(options.tlsCert, options.tlsKey, options.tlsCA)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			os.Exit(1)
 		}
+	}
 
-		var evt string
-		switch msg.Payload {
-		case "":
-			// Ignore user hitting Enter
-		case "H":
-			fmt.Println(help)
-		case "Q":
-			return
-		default:
-			evt = e.Handle(msg.Payload)
-			if evt != "" && !strings.HasPrefix(evt, "crash:") {
-				debug("event: %s\n", evt)
-				sendEvent(evt)
+	ch := make(chan Message)
+	// done is closed when the main loop stops reading ch
+	done := make(chan struct{})
+
+	// 0 is the sim default
+	history := options.history
+	if history == 0 {
+		history = sim.NoHistory
+	}
+
+	s := sim.New(sim.Options{
+		History:          history,
+		QueueSize:        options.queueSize,
+		SlowPolicy:       policy,
+		TLS:              tlsConfig,
+		Token:            options.token,
+		AuthTimeout:      options.authTimeout,
+		MaxLine:          options.maxLine,
+		Rate:             options.rate,
+		Burst:            options.burst,
+		RateDisconnect:   options.rateDisconnect,
+		HeartbeatTimeout: options.hbTimeout,
+		Failsafe:         options.failsafe,
+		OnCommand: func(cmd, evt string) {
+			// Redraw the status line
+			select {
+			case ch <- Message{Origin: "ctrl", Payload: evt}:
+			case <-done:
 			}
+		},
+		Logf: warn,
+	})
+
+	for _, addr := range addrs {
+		lis, err := s.Listen(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			s.Close()
+			os.Exit(1)
 		}
+		debug("listening on %s\n", lis.Addr())
+	}
 
-		state := e.String()
-		if state != lastState || msg.Origin == "stdin" {
-			if msg.Origin != "stdin" || msg.Origin == "ctrl" {
-				fmt.Println()
-			}
-			if strings.HasPrefix(evt, "crash:") {
-				fmt.Printf("\033[31m%s\033[39m\n", evt)
-			}
-			fmt.Print(state)
-			lastState = state
+	hub := NewEventHub()
+	go hub.Feed(s.Events(context.Background()))
+
+	var srv *http.Server
+	if options.httpAddr != "" {
+		lis, err := net.Listen("tcp", options.httpAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			s.Close()
+			os.Exit(1)
+		}
+
+		srv = &http.Server{
+			Handler:   newAPI(s, hub, options.token),
+			TLSConfig: tlsConfig,
 		}
+		go httpListener(srv, lis)
 	}
+	go stdinListener(ch)
+	go sigHandler(ch)
+	go ticker(ch)
+	if options.heartbeat > 0 {
+		go heartbeat(s, options.heartbeat, ch, done)
+	}
+
+	reason := newLoop(s).run(ch, os.Stdout)
+	close(done)
+
+	// SSE handlers return once they sent BYE
+	hub.Close("BYE " + reason)
+	shutdown(s, reason, srv, options.shutdownTimeout)
+
+	fmt.Println()
+	fmt.Println(farewellMessage(s.Crashes()))
+	fmt.Println(report(s.Stats()))
 }
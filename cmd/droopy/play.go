@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"os"
 	"strings"
@@ -10,8 +11,13 @@ import (
 	"github.com/353solutions/droopy"
 )
 
-func playCmd(addr string) error {
-	client, err := droopy.NewClient(droopy.WithAddr(addr))
+func playCmd(addr, token string, tlsConfig *tls.Config) error {
+	opts := []droopy.ClientOption{droopy.WithAddr(addr), droopy.WithToken(token)}
+	if tlsConfig != nil {
+		opts = append(opts, droopy.WithTLS(tlsConfig))
+	}
+
+	client, err := droopy.NewClient(opts...)
 	if err != nil {
 		return fmt.Errorf("connecting to simulator: %w", err)
 	}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/pem"
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/353solutions/droopy/sim"
 )
 
 func waitForServer(t *testing.T, addr string, timeout time.Duration) {
@@ -240,3 +246,44 @@ func TestPlayCmd_Errors(t *testing.T) {
 		})
 	}
 }
+
+func TestPlayCmd_TLS(t *testing.T) {
+	// httptest has a certificate for 127.0.0.1
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := sim.New(sim.Options{TLS: ts.TLS})
+	t.Cleanup(func() { s.Close() })
+
+	lis, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binPath := filepath.Join(t.TempDir(), "droopy-play")
+	buildCmd := exec.Command("go", "build", "-o", binPath, ".")
+	if out, err := buildCmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build droopy: %v\n%s", err, out)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, binPath, "-play", "-addr", lis.Addr().String(), "-tls-ca", caFile)
+	cmd.Stdin = strings.NewReader("SEND P2\nWAIT P2\n")
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("play failed: %v\n%s", err, output)
+	}
+
+	if !strings.Contains(string(output), "< P2") {
+		t.Fatalf("expected P2 in output:\n%s", output)
+	}
+}
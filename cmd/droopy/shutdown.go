@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/353solutions/droopy/sim"
+)
+
+func shutdownReason(msg Message) string {
+	switch {
+	case msg.Origin == "signal":
+		return "interrupted"
+	case msg.Payload == "EOF":
+		return "end of input"
+	}
+
+	return "quit"
+}
+
+// shutdown tells connected clients the simulator is going away (BYE <reason>), flushes their queues and waits for them to close.
+// SSE subscribers should get BYE from hub.Close before calling shutdown.
+func shutdown(s *sim.Sim, reason string, srv *http.Server, timeout time.Duration) {
+	s.Shutdown(reason, timeout)
+
+	if srv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		debug("shutdown: http - %s\n", err)
+	}
+}
+
+func report(stats sim.Stats) string {
+	return fmt.Sprintf(
+		"Session: %d commands, %d events, %d crashes, %d dropped events, %d connection errors, %d rate limited commands.",
+		stats.Commands, stats.Events, stats.Crashes, stats.Dropped, stats.Errors, stats.RateLimited,
+	)
+}
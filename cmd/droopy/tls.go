@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/353solutions/droopy"
+)
+
+// loadTLS loads the server TLS configuration.
+// If caFile is not empty, clients must have a certificate signed by it.
+func loadTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+
+	cfg := tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile == "" {
+		return &cfg, nil
+	}
+
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client CA: %w", err)
+	}
+
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return &cfg, nil
+}
+
+// loadClientTLS loads the TLS configuration for connecting to the simulator at addr (-play).
+// The certificate is optional, if caFile is empty the simulator certificate is verified with the system CAs.
+func loadClientTLS(certFile, keyFile, caFile, addr string) (*tls.Config, error) {
+	cfg := tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading CA: %w", err)
+		}
+		cfg.RootCAs = pool
+	}
+
+	// Unix sockets and addresses such as ":10000" have no host name to verify
+	network, address, err := droopy.SplitAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(address)
+	if network == "unix" || host == "" {
+		cfg.ServerName = "localhost"
+	}
+
+	return &cfg, nil
+}
+
+// loadCertPool loads the PEM certificates in file.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates found", file)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,145 @@
+package droopy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Op is a command operation.
+type Op byte
+
+const (
+	OpMoveUp     Op = iota + 1 // MU
+	OpMoveDown                 // MD
+	OpStop                     // S
+	OpOpenDoor                 // DO
+	OpCloseDoor                // DC
+	OpPressPanel               // Pn
+	OpPressUp                  // Un
+	OpPressDown                // Dn
+	OpClearPanel               // CPn
+	OpClearUp                  // CUn
+	OpClearDown                // CDn
+	OpReset                    // R
+)
+
+// opCodes are the protocol codes of operations, ops with a floor are followed by the floor number.
+var opCodes = map[Op]string{
+	OpMoveUp:     "MU",
+	OpMoveDown:   "MD",
+	OpStop:       "S",
+	OpOpenDoor:   "DO",
+	OpCloseDoor:  "DC",
+	OpPressPanel: "P",
+	OpPressUp:    "U",
+	OpPressDown:  "D",
+	OpClearPanel: "CP",
+	OpClearUp:    "CU",
+	OpClearDown:  "CD",
+	OpReset:      "R",
+}
+
+// opFloors are the valid floors of operations with a floor.
+var opFloors = map[Op]floorRange{
+	OpPressPanel: floorKinds[PanelPressed],
+	OpPressUp:    floorKinds[UpPressed],
+	OpPressDown:  floorKinds[DownPressed],
+	OpClearPanel: floorKinds[PanelCleared],
+	OpClearUp:    floorKinds[UpCleared],
+	OpClearDown:  floorKinds[DownCleared],
+}
+
+// HasFloor reports if op commands have a floor.
+func (op Op) HasFloor() bool {
+	_, ok := opFloors[op]
+	return ok
+}
+
+// Command is a command to the simulator.
+type Command struct {
+	Op    Op
+	Floor int // For operations with a floor (see Op.HasFloor)
+}
+
+// Command constructors, e.g. ClearPanel(3) is "CP3".
+
+func MoveUp() Command              { return Command{Op: OpMoveUp} }
+func MoveDown() Command            { return Command{Op: OpMoveDown} }
+func Stop() Command                { return Command{Op: OpStop} }
+func OpenDoor() Command            { return Command{Op: OpOpenDoor} }
+func CloseDoor() Command           { return Command{Op: OpCloseDoor} }
+func PressPanel(floor int) Command { return Command{Op: OpPressPanel, Floor: floor} }
+func PressUp(floor int) Command    { return Command{Op: OpPressUp, Floor: floor} }
+func PressDown(floor int) Command  { return Command{Op: OpPressDown, Floor: floor} }
+func ClearPanel(floor int) Command { return Command{Op: OpClearPanel, Floor: floor} }
+func ClearUp(floor int) Command    { return Command{Op: OpClearUp, Floor: floor} }
+func ClearDown(floor int) Command  { return Command{Op: OpClearDown, Floor: floor} }
+func ResetElevator() Command       { return Command{Op: OpReset} }
+
+// String returns the command in the simulator protocol format (e.g. "CP3").
+func (c Command) String() string {
+	code, ok := opCodes[c.Op]
+	if !ok {
+		return fmt.Sprintf("Command(%d)", c.Op)
+	}
+
+	if c.Op.HasFloor() {
+		return fmt.Sprintf("%s%d", code, c.Floor)
+	}
+
+	return code
+}
+
+// Validate checks that c is a valid command.
+func (c Command) Validate() error {
+	if _, ok := opCodes[c.Op]; !ok {
+		return fmt.Errorf("unknown operation: %d", c.Op)
+	}
+
+	r, ok := opFloors[c.Op]
+	if !ok {
+		return nil
+	}
+
+	if c.Floor < r.min || c.Floor > r.max {
+		return fmt.Errorf("%s: floor %d out of range [%d, %d]", c, c.Floor, r.min, r.max)
+	}
+
+	return nil
+}
+
+// ParseCommand parses a command in the simulator protocol format.
+func ParseCommand(s string) (Command, error) {
+	for op, code := range opCodes {
+		if op.HasFloor() {
+			continue
+		}
+
+		if s == code {
+			return Command{Op: op}, nil
+		}
+	}
+
+	i := strings.IndexAny(s, "0123456789")
+	if i == -1 {
+		return Command{}, fmt.Errorf("%q: unknown command", s)
+	}
+
+	floor, err := strconv.Atoi(s[i:])
+	if err != nil || len(s[i:]) != 1 { // single digit floors
+		return Command{}, fmt.Errorf("%q: bad floor", s)
+	}
+
+	for op := range opFloors {
+		if opCodes[op] == s[:i] {
+			cmd := Command{Op: op, Floor: floor}
+			if err := cmd.Validate(); err != nil {
+				return Command{}, err
+			}
+			return cmd, nil
+		}
+	}
+
+	return Command{}, fmt.Errorf("%q: unknown command", s)
+}
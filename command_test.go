@@ -0,0 +1,60 @@
+package droopy
+
+import (
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	cases := []struct {
+		cmd  Command
+		text string
+	}{
+		{MoveUp(), "MU"},
+		{MoveDown(), "MD"},
+		{Stop(), "S"},
+		{OpenDoor(), "DO"},
+		{CloseDoor(), "DC"},
+		{PressPanel(4), "P4"},
+		{PressUp(1), "U1"},
+		{PressDown(2), "D2"},
+		{ClearPanel(3), "CP3"},
+		{ClearUp(3), "CU3"},
+		{ClearDown(4), "CD4"},
+		{ResetElevator(), "R"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.text, func(t *testing.T) {
+			if err := tc.cmd.Validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			if s := tc.cmd.String(); s != tc.text {
+				t.Fatalf("expected %q, got %q", tc.text, s)
+			}
+
+			cmd, err := ParseCommand(tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cmd != tc.cmd {
+				t.Fatalf("expected %+v, got %+v", tc.cmd, cmd)
+			}
+		})
+	}
+}
+
+func TestCommand_Invalid(t *testing.T) {
+	for _, cmd := range []Command{ClearPanel(0), ClearPanel(5), PressUp(4), PressDown(1), {}} {
+		if err := cmd.Validate(); err == nil {
+			t.Errorf("%s: expected error", cmd)
+		}
+	}
+
+	for _, s := range []string{"", "M", "MX", "P", "P5", "P10", "CP", "XP1", "mu", "MU "} {
+		if cmd, err := ParseCommand(s); err == nil {
+			t.Errorf("%q: expected error, got %+v", s, cmd)
+		}
+	}
+}
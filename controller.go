@@ -0,0 +1,121 @@
+package droopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// Controller handles simulator events, see Run.
+type Controller interface {
+	// OnEvent is called for every event, it sends commands with act.
+	// Returning an error stops Run.
+	OnEvent(ctx context.Context, evt Event, act Actions) error
+}
+
+// Ticker is an optional interface for a Controller.
+// OnTick is called on every simulator tick (10 times a second in simulator time).
+type Ticker interface {
+	OnTick(ctx context.Context, act Actions) error
+}
+
+// Actions lets a controller send commands and look at the elevator state.
+type Actions interface {
+	// Send sends cmd to the simulator.
+	Send(cmd Command) error
+	// State returns the current elevator state, as mirrored by the client.
+	State() StateView
+}
+
+type clientActions struct {
+	ctx context.Context
+	c   *Client
+}
+
+func (a clientActions) Send(cmd Command) error {
+	return a.c.SendCommand(a.ctx, cmd)
+}
+
+func (a clientActions) State() StateView {
+	return a.c.State().View()
+}
+
+// PanicError is returned by Run when the controller panics.
+type PanicError struct {
+	Value any    // value passed to panic
+	Stack []byte // stack trace of the panic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("controller panic: %v", e.Value)
+}
+
+// Run runs ctrl with events from c until ctx is done, then it returns nil.
+// If c was created WithReconnect, Run goes on when the connection is lost and ctrl gets a Reconnected event,
+// otherwise a lost connection stops Run with an error.
+// When the simulator says BYE and closes the connection (it shut down, or kicked a client WithReconnect),
+// Run returns an error wrapping ErrBye.
+// Errors returned by ctrl stop Run, panics in ctrl are returned as *PanicError.
+// Run owns c while it's running, don't use c from other goroutines except for sending.
+func Run(ctx context.Context, c *Client, ctrl Controller) error {
+	ticker, hasTick := ctrl.(Ticker)
+	if err := runSetup(ctx, c, hasTick); err != nil {
+		return stopErr(ctx, err)
+	}
+
+	act := clientActions{ctx: ctx, c: c}
+	for {
+		evt, err := c.RecvEvent(ctx)
+		if err != nil {
+			return stopErr(ctx, err)
+		}
+
+		switch {
+		case evt.Kind == Tick && hasTick:
+			err = safeCall(func() error { return ticker.OnTick(ctx, act) })
+		case evt.Kind == Tick:
+			// Not subscribed, probably someone else subscribed the connection
+		default:
+			// The client subscribes to ticks again on reconnect
+			err = safeCall(func() error { return ctrl.OnEvent(ctx, evt, act) })
+		}
+
+		if err != nil {
+			return stopErr(ctx, err)
+		}
+	}
+}
+
+// runSetup syncs the client state and subscribes to ticks if needed.
+func runSetup(ctx context.Context, c *Client, tick bool) error {
+	if err := c.Sync(ctx); err != nil {
+		return err
+	}
+
+	if !tick {
+		return nil
+	}
+
+	return c.SendContext(ctx, "SUB tick")
+}
+
+// stopErr returns the error Run returns for err, nil if ctx is done.
+func stopErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return nil
+	}
+
+	return err
+}
+
+// safeCall calls fn, converting a panic to *PanicError.
+func safeCall(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+
+	return fn()
+}
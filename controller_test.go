@@ -0,0 +1,151 @@
+package droopy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// upController goes up to floor 2 when P2 is pressed.
+type upController struct {
+	ticks int
+}
+
+func (c *upController) OnEvent(ctx context.Context, evt Event, act Actions) error {
+	switch {
+	case evt.Kind == PanelPressed && evt.Floor == 2:
+		return act.Send(MoveUp())
+	case evt.Kind == Approaching && evt.Floor == 2:
+		return act.Send(Stop())
+	case evt.Kind == PanelPressed && evt.Floor == 4:
+		panic("can't go that high")
+	}
+
+	return nil
+}
+
+func (c *upController) OnTick(ctx context.Context, act Actions) error {
+	c.ticks++
+	return nil
+}
+
+// serverLines sends lines the client sent to srv on the returned channel.
+func serverLines(srv net.Conn) <-chan string {
+	ch := make(chan string, 16)
+	go func() {
+		defer close(ch)
+		s := bufio.NewScanner(srv)
+		for s.Scan() {
+			ch <- s.Text()
+		}
+	}()
+
+	return ch
+}
+
+func expectCmd(t *testing.T, lines <-chan string, want string) {
+	t.Helper()
+
+	select {
+	case line := <-lines:
+		if line != want {
+			t.Fatalf("expected %q, got %q", want, line)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for %q", want)
+	}
+}
+
+// runController starts Run with ctrl against a fake server, it returns the server connection,
+// the lines the client sent (after the handshake) and Run's error channel.
+func runController(t *testing.T, ctx context.Context, ctrl Controller) (net.Conn, <-chan string, <-chan error) {
+	t.Helper()
+
+	addr, conns := fakeServer(t)
+	c, err := NewClient(WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	srv := <-conns
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- Run(ctx, c, ctrl)
+	}()
+
+	lines := serverLines(srv)
+	expectCmd(t, lines, "SUB crash")
+	fmt.Fprintln(srv, "OK SUB buttons,approach,stop,door,crash")
+	expectCmd(t, lines, "STATE")
+	fmt.Fprintln(srv, `STATE {"floor":1,"motor":"OFF","door":"CLOSED","panel":[],"up":[],"down":[]}`)
+
+	return srv, lines, errc
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := &upController{}
+	srv, lines, errc := runController(t, ctx, ctrl)
+	expectCmd(t, lines, "SUB tick")
+
+	fmt.Fprint(srv, "P2 #1\nT\nT\n")
+	expectCmd(t, lines, "MU")
+
+	fmt.Fprint(srv, "A2 #2\n")
+	expectCmd(t, lines, "S")
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("expected nil on cancel, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't stop on cancel")
+	}
+
+	if ctrl.ticks != 2 {
+		t.Fatalf("expected 2 ticks, got %d", ctrl.ticks)
+	}
+}
+
+func TestRun_Bye(t *testing.T) {
+	srv, _, errc := runController(t, context.Background(), &upController{})
+
+	fmt.Fprint(srv, "BYE quit\n")
+	srv.Close()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrBye) || err.Error() != "simulator said BYE: quit" {
+			t.Fatalf("expected ErrBye, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after BYE")
+	}
+}
+
+func TestRun_Panic(t *testing.T) {
+	srv, _, errc := runController(t, context.Background(), &upController{})
+
+	fmt.Fprint(srv, "P4 #1\n")
+	select {
+	case err := <-errc:
+		var perr *PanicError
+		if !errors.As(err, &perr) {
+			t.Fatalf("expected PanicError, got %v", err)
+		}
+
+		if perr.Value != "can't go that high" || len(perr.Stack) == 0 {
+			t.Fatalf("bad panic error: %+v", perr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after panic")
+	}
+}
@@ -0,0 +1,199 @@
+// Package controllers has reference elevator controllers built on droopy.Client.
+//
+// All controllers share the same door and motor handling, they differ in the dispatch policy:
+// which floor to stop at and where to go next.
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/353solutions/droopy"
+)
+
+// policy is a dispatch algorithm.
+type policy interface {
+	// request is called when a button at floor is pressed.
+	request(floor int)
+	// stopAt reports if the car, moving in dir, should stop at floor.
+	stopAt(v droopy.StateView, floor int, dir droopy.Direction) bool
+	// direction returns where to go from floor after moving in dir (DirectionNone if idle).
+	// DirectionNone means the car should stay (e.g. the only calls are at floor).
+	direction(v droopy.StateView, floor int, dir droopy.Direction) droopy.Direction
+	// served returns the commands clearing the buttons served at floor when leaving in dir.
+	served(v droopy.StateView, floor int, dir droopy.Direction) []droopy.Command
+}
+
+// controller drives the car according to a policy.
+type controller struct {
+	policy policy
+	dir    droopy.Direction // travel direction, kept while stopped at a floor
+}
+
+// OnEvent implements droopy.Controller.
+func (c *controller) OnEvent(ctx context.Context, evt droopy.Event, act droopy.Actions) error {
+	v := act.State()
+
+	switch evt.Kind {
+	case droopy.PanelPressed, droopy.UpPressed, droopy.DownPressed:
+		c.policy.request(evt.Floor)
+		if idle(v) {
+			return c.dispatch(v, act)
+		}
+	case droopy.Approaching:
+		if !v.Moving() || v.Stopping {
+			return nil
+		}
+
+		if terminal(evt.Floor, c.dir) || c.policy.stopAt(v, evt.Floor, c.dir) {
+			return act.Send(droopy.Stop())
+		}
+	case droopy.Stopped, droopy.DoorClosed:
+		return c.dispatch(v, act)
+	case droopy.DoorOpened:
+		c.dir = c.policy.direction(v, evt.Floor, c.dir)
+		for _, cmd := range c.policy.served(v, evt.Floor, c.dir) {
+			if err := act.Send(cmd); err != nil {
+				return err
+			}
+		}
+		return act.Send(droopy.CloseDoor())
+	case droopy.Reset, droopy.Reconnected, droopy.StateReceived:
+		return c.resync(v, act)
+	}
+
+	return nil
+}
+
+// dispatch serves the current floor or moves the idle car.
+func (c *controller) dispatch(v droopy.StateView, act droopy.Actions) error {
+	c.dir = c.policy.direction(v, v.Floor, c.dir)
+
+	if len(c.policy.served(v, v.Floor, c.dir)) > 0 {
+		return act.Send(droopy.OpenDoor())
+	}
+
+	switch c.dir {
+	case droopy.DirectionUp:
+		return act.Send(droopy.MoveUp())
+	case droopy.DirectionDown:
+		return act.Send(droopy.MoveDown())
+	}
+
+	return nil
+}
+
+// resync continues from a state we didn't follow (e.g. after a reconnect).
+func (c *controller) resync(v droopy.StateView, act droopy.Actions) error {
+	for f := 1; f <= droopy.MaxFloor; f++ {
+		if hasCall(v, f) {
+			c.policy.request(f)
+		}
+	}
+
+	switch {
+	case v.Crashed:
+		return nil
+	case v.Moving():
+		c.dir = v.Direction
+		return nil
+	case v.Door == droopy.DoorStateOpen:
+		return act.Send(droopy.CloseDoor())
+	case idle(v):
+		return c.dispatch(v, act)
+	}
+
+	// Door is opening or closing, we'll get an event
+	return nil
+}
+
+// idle reports if the car is stopped with the door closed.
+func idle(v droopy.StateView) bool {
+	return !v.Moving() && v.Door == droopy.DoorStateClosed && !v.Crashed
+}
+
+// terminal reports if floor is the last floor when moving in dir, the car must stop there.
+func terminal(floor int, dir droopy.Direction) bool {
+	return (dir == droopy.DirectionUp && floor == droopy.MaxFloor) || (dir == droopy.DirectionDown && floor == 1)
+}
+
+func hasCall(v droopy.StateView, floor int) bool {
+	return v.Panel[floor] || v.Up[floor] || v.Down[floor]
+}
+
+// callsAhead reports if there are calls after floor in dir.
+func callsAhead(v droopy.StateView, floor int, dir droopy.Direction) bool {
+	switch dir {
+	case droopy.DirectionUp:
+		for f := floor + 1; f <= droopy.MaxFloor; f++ {
+			if hasCall(v, f) {
+				return true
+			}
+		}
+	case droopy.DirectionDown:
+		for f := floor - 1; f >= 1; f-- {
+			if hasCall(v, f) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func reverse(dir droopy.Direction) droopy.Direction {
+	switch dir {
+	case droopy.DirectionUp:
+		return droopy.DirectionDown
+	case droopy.DirectionDown:
+		return droopy.DirectionUp
+	}
+
+	return droopy.DirectionNone
+}
+
+// collectiveServed clears the panel button and the hall button in dir, or both hall buttons if dir is DirectionNone.
+func collectiveServed(v droopy.StateView, floor int, dir droopy.Direction) []droopy.Command {
+	var cmds []droopy.Command
+	if v.Panel[floor] {
+		cmds = append(cmds, droopy.ClearPanel(floor))
+	}
+
+	if v.Up[floor] && dir != droopy.DirectionDown {
+		cmds = append(cmds, droopy.ClearUp(floor))
+	}
+
+	if v.Down[floor] && dir != droopy.DirectionUp {
+		cmds = append(cmds, droopy.ClearDown(floor))
+	}
+
+	return cmds
+}
+
+// constructors are the controllers by algorithm name.
+var constructors = map[string]func() droopy.Controller{
+	"fcfs": NewFCFS,
+	"scan": NewSCAN,
+	"look": NewLOOK,
+}
+
+// Algorithms returns the names of the available algorithms.
+func Algorithms() []string {
+	names := make([]string, 0, len(constructors))
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a controller for algorithm name (see Algorithms).
+func New(name string) (droopy.Controller, error) {
+	fn, ok := constructors[name]
+	if !ok {
+		return nil, fmt.Errorf("%q: unknown algorithm", name)
+	}
+
+	return fn(), nil
+}
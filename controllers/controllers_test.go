@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/353solutions/droopy"
+	"github.com/353solutions/droopy/droopytest"
+	"github.com/353solutions/droopy/sim"
+)
+
+// tick is the simulator tick, long enough for the controller to stop the car after an approach event.
+const tick = 5 * time.Millisecond
+
+// startSim starts an in-process simulator and returns its address.
+func startSim(t *testing.T) string {
+	t.Helper()
+
+	s := sim.New(sim.Options{Tick: tick})
+	t.Cleanup(func() { s.Close() })
+
+	lis, err := s.Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Run(context.Background())
+	return lis.Addr().String()
+}
+
+// allServed reports if there are no lit buttons and the car is idle.
+func allServed(v droopy.StateView) bool {
+	for f := 1; f <= droopy.MaxFloor; f++ {
+		if hasCall(v, f) {
+			return false
+		}
+	}
+
+	return idle(v)
+}
+
+func TestControllers(t *testing.T) {
+	for _, algo := range Algorithms() {
+		t.Run(algo, func(t *testing.T) {
+			t.Parallel()
+			testController(t, algo)
+		})
+	}
+}
+
+func testController(t *testing.T, algo string) {
+	addr := startSim(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// The guard fails the controller on any command that would crash the elevator
+	c, err := droopy.NewClient(droopy.WithAddr(addr), droopy.WithGuard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Send("LOCK"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl, err := New(algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- droopy.Run(ctx, c, ctrl)
+	}()
+
+	p, err := droopy.NewClient(droopy.WithAddr(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for _, cmd := range []string{"ROLE passenger", "SUB crash"} {
+		if err := p.Send(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var pressed atomic.Bool
+	go func() {
+		batches := [][]droopy.Command{
+			{droopy.PressPanel(3), droopy.PressDown(4), droopy.PressUp(2)},
+			{droopy.PressPanel(1), droopy.PressDown(3), droopy.PressUp(1)},
+		}
+		for i, batch := range batches {
+			if i > 0 {
+				time.Sleep(2 * sim.TicksPerFloor * tick)
+			}
+			for _, cmd := range batch {
+				if err := p.SendCommand(ctx, cmd); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+		pressed.Store(true)
+	}()
+
+	for {
+		evt, err := p.RecvEvent(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+
+		switch evt.Kind {
+		case droopy.Crashed:
+			t.Fatalf("%s: crashed: %s", algo, evt.Reason)
+		case droopy.Rejected:
+			t.Fatalf("%s: rejected: %s", algo, evt)
+		}
+
+		select {
+		case err := <-errc:
+			t.Fatalf("%s: controller stopped: %v", algo, err)
+		default:
+		}
+
+		if pressed.Load() && allServed(p.State().View()) {
+			break
+		}
+	}
+
+	cancel()
+	if err := <-errc; err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("%s: %v", algo, err)
+	}
+}
+
+func TestLOOK_HallCalls(t *testing.T) {
+	f := droopytest.New(t)
+	f.FailOnCrash(t)
+	f.Run(NewLOOK())
+
+	// The down call at 2 is served on the way back
+	f.Press("P4")
+	f.Press("D2")
+	f.ExpectCommand(t, "MU")
+	f.Advance(3*sim.TicksPerFloor - sim.ApproachTicks) // A2, A3, A4
+	f.ExpectCommand(t, "S")
+	f.Advance(sim.ApproachTicks)
+	f.ExpectCommand(t, "DO")
+	f.Advance(sim.TicksPerDoor + 1)
+	f.ExpectCommand(t, "CP4")
+	f.ExpectCommand(t, "DC")
+	f.Advance(sim.TicksPerDoor + 1)
+	f.ExpectCommand(t, "MD")
+	f.Advance(2*sim.TicksPerFloor - sim.ApproachTicks) // A3, A2
+	f.ExpectCommand(t, "S")
+	f.Advance(sim.ApproachTicks)
+	f.ExpectCommand(t, "DO")
+	f.Advance(sim.TicksPerDoor + 1)
+	f.ExpectCommand(t, "CD2")
+	f.ExpectCommand(t, "DC")
+	f.Advance(sim.TicksPerDoor + 1)
+
+	st := f.Sim().State()
+	if st.Floor != 2 || st.Door != "CLOSED" || len(st.Panel)+len(st.Up)+len(st.Down) != 0 {
+		t.Fatalf("bad state: %+v", st)
+	}
+}
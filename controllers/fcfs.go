@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"slices"
+
+	"github.com/353solutions/droopy"
+)
+
+// fcfs serves floors in the order they were requested, it stops only at the requested floor.
+type fcfs struct {
+	queue []int // requested floors, in order
+}
+
+// NewFCFS returns a naive first come, first served controller.
+func NewFCFS() droopy.Controller {
+	return &controller{policy: &fcfs{}}
+}
+
+func (p *fcfs) request(floor int) {
+	if !slices.Contains(p.queue, floor) {
+		p.queue = append(p.queue, floor)
+	}
+}
+
+// target returns the next requested floor, 0 if there's none.
+// Floors with no lit buttons (served, or cleared by someone else) are dropped.
+func (p *fcfs) target(v droopy.StateView) int {
+	for len(p.queue) > 0 && !hasCall(v, p.queue[0]) {
+		p.queue = p.queue[1:]
+	}
+
+	if len(p.queue) == 0 {
+		return 0
+	}
+
+	return p.queue[0]
+}
+
+func (p *fcfs) stopAt(v droopy.StateView, floor int, dir droopy.Direction) bool {
+	return p.target(v) == floor
+}
+
+func (p *fcfs) direction(v droopy.StateView, floor int, dir droopy.Direction) droopy.Direction {
+	switch t := p.target(v); {
+	case t == 0 || t == floor:
+		return droopy.DirectionNone
+	case t > floor:
+		return droopy.DirectionUp
+	default:
+		return droopy.DirectionDown
+	}
+}
+
+// served clears all the buttons at the target floor.
+func (p *fcfs) served(v droopy.StateView, floor int, dir droopy.Direction) []droopy.Command {
+	if p.target(v) != floor {
+		return nil
+	}
+
+	return collectiveServed(v, floor, droopy.DirectionNone)
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"github.com/353solutions/droopy"
+)
+
+// scan is the collective (elevator) algorithm: the car sweeps to the end of the building and back,
+// stopping for car calls and for hall calls in its direction.
+// With look, the car turns around when there are no more calls ahead.
+type scan struct {
+	look bool
+}
+
+// NewSCAN returns a SCAN controller.
+func NewSCAN() droopy.Controller {
+	return &controller{policy: &scan{}}
+}
+
+// NewLOOK returns a LOOK controller, it's like SCAN but doesn't go past the last call.
+// A hall call in the opposite direction is served on the way if it's the last call ahead.
+func NewLOOK() droopy.Controller {
+	return &controller{policy: &scan{look: true}}
+}
+
+func (p *scan) request(floor int) {}
+
+func (p *scan) stopAt(v droopy.StateView, floor int, dir droopy.Direction) bool {
+	switch {
+	case v.Panel[floor]:
+		return true
+	case dir == droopy.DirectionUp && v.Up[floor]:
+		return true
+	case dir == droopy.DirectionDown && v.Down[floor]:
+		return true
+	case p.look:
+		// Last call ahead, turn around here
+		return hasCall(v, floor) && !callsAhead(v, floor, dir)
+	}
+
+	return false
+}
+
+func (p *scan) direction(v droopy.StateView, floor int, dir droopy.Direction) droopy.Direction {
+	up, down := callsAhead(v, floor, droopy.DirectionUp), callsAhead(v, floor, droopy.DirectionDown)
+	if !up && !down {
+		// Nothing to do or calls only at this floor
+		return droopy.DirectionNone
+	}
+
+	if dir == droopy.DirectionNone {
+		if up {
+			return droopy.DirectionUp
+		}
+		return droopy.DirectionDown
+	}
+
+	if p.look {
+		if callsAhead(v, floor, dir) {
+			return dir
+		}
+		return reverse(dir)
+	}
+
+	// SCAN goes all the way to the end
+	if terminal(floor, dir) {
+		return reverse(dir)
+	}
+	return dir
+}
+
+func (p *scan) served(v droopy.StateView, floor int, dir droopy.Direction) []droopy.Command {
+	return collectiveServed(v, floor, dir)
+}
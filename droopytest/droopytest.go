@@ -0,0 +1,228 @@
+// Package droopytest provides a fake simulator for controller unit tests.
+//
+// The fake runs the simulator in-process, connected to a droopy.Client over net.Pipe.
+// Time doesn't pass on its own, the test advances it with Advance.
+// Wait for the controller's reaction with ExpectCommand before advancing further,
+// for example the controller must stop the car between the approach event and the arrival:
+//
+//	f := droopytest.New(t)
+//	f.FailOnCrash(t)
+//	f.Run(ctrl)
+//
+//	f.Press("P2")
+//	f.ExpectCommand(t, "MU")
+//	f.Advance(sim.TicksPerFloor - sim.ApproachTicks) // A2
+//	f.ExpectCommand(t, "S")
+//	f.Advance(sim.ApproachTicks) // S2
+//	f.ExpectCommand(t, "DO")
+package droopytest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/353solutions/droopy"
+	"github.com/353solutions/droopy/sim"
+)
+
+// Timeout is how long ExpectCommand waits for a command.
+var Timeout = time.Second
+
+// Fake is a fake simulator for a single controller.
+type Fake struct {
+	t      testing.TB
+	sim    *sim.Sim
+	client *droopy.Client
+
+	mu       sync.Mutex
+	cmds     []string      // commands from the controller, in order
+	expected int           // commands checked by ExpectCommand
+	added    chan struct{} // closed when a command is added
+	crash    string        // reason of the first crash
+	crashT   testing.TB    // set by FailOnCrash
+}
+
+// New returns a fake simulator with a connected client, they are closed when the test ends.
+func New(t testing.TB) *Fake {
+	t.Helper()
+
+	f := Fake{
+		t:     t,
+		added: make(chan struct{}),
+	}
+	f.sim = sim.New(sim.Options{OnCommand: f.record})
+	t.Cleanup(func() { f.sim.Close() })
+
+	c, err := droopy.NewClient(droopy.WithDialer(f.dial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	f.client = c
+
+	// The round trip makes sure the connection is served before the test presses buttons
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return &f
+}
+
+// dial connects to the simulator over net.Pipe.
+func (f *Fake) dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	go f.sim.ServeConn(server)
+	return client, nil
+}
+
+func (f *Fake) record(cmd, evt string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setCrash(evt)
+	f.cmds = append(f.cmds, cmd)
+	close(f.added)
+	f.added = make(chan struct{})
+}
+
+// setCrash records the crash reason if evt is a crash, f.mu must be held.
+func (f *Fake) setCrash(evt string) {
+	if reason, ok := strings.CutPrefix(evt, "CRASH "); ok && f.crash == "" {
+		f.crash = reason
+	}
+}
+
+// Client returns the client connected to the fake.
+func (f *Fake) Client() *droopy.Client {
+	return f.client
+}
+
+// Sim returns the underlying simulator, e.g. to check its state.
+func (f *Fake) Sim() *sim.Sim {
+	return f.sim
+}
+
+// Run runs ctrl with the fake client in the background until the test ends.
+// The test fails if ctrl returns an error or panics.
+func (f *Fake) Run(ctrl droopy.Controller) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- droopy.Run(ctx, f.client, ctrl)
+	}()
+
+	f.t.Cleanup(func() {
+		cancel()
+		err := <-errc
+		if err != nil && !errors.Is(err, droopy.ErrClosed) {
+			f.t.Errorf("controller: %v", err)
+		}
+	})
+}
+
+// Press presses a button (e.g. "U3"), as a passenger would.
+func (f *Fake) Press(button string) {
+	f.t.Helper()
+
+	if err := f.sim.Press(button); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// Advance advances the simulation by ticks.
+func (f *Fake) Advance(ticks int) {
+	f.t.Helper()
+
+	for range ticks {
+		evt := f.sim.Step()
+		f.mu.Lock()
+		f.setCrash(evt)
+		f.mu.Unlock()
+	}
+	f.checkCrash()
+}
+
+// Commands returns the commands the fake got so far.
+func (f *Fake) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.cmds...)
+}
+
+// ExpectCommand fails t if the next command from the controller is not cmd.
+// It waits up to Timeout for the command, once it returns the simulator has handled it.
+func (f *Fake) ExpectCommand(t testing.TB, cmd string) {
+	t.Helper()
+
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+
+	for {
+		f.mu.Lock()
+		if f.expected < len(f.cmds) {
+			got := f.cmds[f.expected]
+			f.expected++
+			f.mu.Unlock()
+
+			if got != cmd {
+				t.Fatalf("expected command %q, got %q", cmd, got)
+			}
+			f.checkCrash()
+			return
+		}
+		added := f.added
+		f.mu.Unlock()
+
+		select {
+		case <-added:
+		case <-timer.C:
+			t.Fatalf("expected command %q, got none after %v", cmd, Timeout)
+		}
+	}
+}
+
+// FailOnCrash fails t when the elevator crashes.
+// Crashes are checked by Advance, ExpectCommand and when the test ends.
+func (f *Fake) FailOnCrash(t testing.TB) {
+	f.mu.Lock()
+	f.crashT = t
+	f.mu.Unlock()
+
+	t.Cleanup(func() {
+		// checkCrash already reported it
+		if t.Failed() {
+			return
+		}
+
+		if reason := f.crashReason(); reason != "" {
+			t.Errorf("elevator crashed: %s (commands: %v)", reason, f.Commands())
+		}
+	})
+}
+
+// crashReason returns the reason of the first crash, empty if there was none.
+func (f *Fake) crashReason() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.crash
+}
+
+func (f *Fake) checkCrash() {
+	f.mu.Lock()
+	t, reason := f.crashT, f.crash
+	f.mu.Unlock()
+
+	if t == nil || reason == "" {
+		return
+	}
+
+	t.Helper()
+	t.Fatalf("elevator crashed: %s (commands: %v)", reason, f.Commands())
+}
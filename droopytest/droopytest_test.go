@@ -0,0 +1,81 @@
+package droopytest_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/353solutions/droopy"
+	"github.com/353solutions/droopy/droopytest"
+	"github.com/353solutions/droopy/sim"
+)
+
+// upOne moves the car one floor up on a panel press.
+type upOne struct{}
+
+func (upOne) OnEvent(ctx context.Context, evt droopy.Event, act droopy.Actions) error {
+	switch evt.Kind {
+	case droopy.PanelPressed:
+		return act.Send(droopy.MoveUp())
+	case droopy.Approaching:
+		return act.Send(droopy.Stop())
+	case droopy.Stopped:
+		return act.Send(droopy.OpenDoor())
+	case droopy.DoorOpened:
+		if err := act.Send(droopy.ClearPanel(evt.Floor)); err != nil {
+			return err
+		}
+		return act.Send(droopy.CloseDoor())
+	}
+
+	return nil
+}
+
+func TestFake(t *testing.T) {
+	f := droopytest.New(t)
+	f.FailOnCrash(t)
+	f.Run(upOne{})
+
+	f.Press("P2")
+	f.ExpectCommand(t, "MU")
+	f.Advance(sim.TicksPerFloor - sim.ApproachTicks)
+	f.ExpectCommand(t, "S")
+	f.Advance(sim.ApproachTicks)
+	f.ExpectCommand(t, "DO")
+	f.Advance(sim.TicksPerDoor + 1)
+	f.ExpectCommand(t, "CP2")
+	f.ExpectCommand(t, "DC")
+	f.Advance(sim.TicksPerDoor + 1)
+
+	if cmds := fmt.Sprint(f.Commands()); cmds != "[MU S DO CP2 DC]" {
+		t.Fatalf("bad commands: %s", cmds)
+	}
+
+	st := f.Sim().State()
+	if st.Floor != 2 || st.Door != "CLOSED" || len(st.Panel) != 0 {
+		t.Fatalf("bad state: %+v", st)
+	}
+}
+
+func TestFake_Client(t *testing.T) {
+	f := droopytest.New(t)
+
+	ctx := context.Background()
+	if err := f.Client().Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Press("U3")
+	evt, err := f.Client().RecvEvent(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if evt.Kind != droopy.UpPressed || evt.Floor != 3 {
+		t.Fatalf("bad event: %s", evt)
+	}
+
+	if !f.Client().State().View().Up[3] {
+		t.Fatal("U3 not lit in client state")
+	}
+}
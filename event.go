@@ -0,0 +1,210 @@
+package droopy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxFloor is the top floor of the building, floors start at 1.
+const MaxFloor = 4
+
+// Kind is the kind of an Event.
+type Kind byte
+
+const (
+	PanelPressed  Kind = iota + 1 // Pn
+	UpPressed                     // Un
+	DownPressed                   // Dn
+	PanelCleared                  // CPn
+	UpCleared                     // CUn
+	DownCleared                   // CDn
+	Approaching                   // An
+	Stopped                       // Sn
+	DoorOpened                    // On
+	DoorClosed                    // Cn
+	Crashed                       // CRASH <reason>
+	Reset                         // RESET
+	Tick                          // T
+	Rejected                      // ERR <command>: <reason>
+	Promoted                      // PROMOTED
+	Bye                           // BYE <reason>
+	StateReceived                 // STATE <json>
+	Reconnected                   // Sent by the client after it reconnects (see WithReconnect)
+)
+
+var kindNames = map[Kind]string{
+	PanelPressed:  "PanelPressed",
+	UpPressed:     "UpPressed",
+	DownPressed:   "DownPressed",
+	PanelCleared:  "PanelCleared",
+	UpCleared:     "UpCleared",
+	DownCleared:   "DownCleared",
+	Approaching:   "Approaching",
+	Stopped:       "Stopped",
+	DoorOpened:    "DoorOpened",
+	DoorClosed:    "DoorClosed",
+	Crashed:       "Crashed",
+	Reset:         "Reset",
+	Tick:          "Tick",
+	Rejected:      "Rejected",
+	Promoted:      "Promoted",
+	Bye:           "Bye",
+	StateReceived: "StateReceived",
+	Reconnected:   "Reconnected",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// floorRange is the prefix and valid floors of events with a floor.
+type floorRange struct {
+	prefix string
+	min    int
+	max    int
+}
+
+var floorKinds = map[Kind]floorRange{
+	PanelPressed: {"P", 1, MaxFloor},
+	UpPressed:    {"U", 1, MaxFloor - 1},
+	DownPressed:  {"D", 2, MaxFloor},
+	PanelCleared: {"CP", 1, MaxFloor},
+	UpCleared:    {"CU", 1, MaxFloor - 1},
+	DownCleared:  {"CD", 2, MaxFloor},
+	Approaching:  {"A", 1, MaxFloor},
+	Stopped:      {"S", 1, MaxFloor},
+	DoorOpened:   {"O", 1, MaxFloor},
+	DoorClosed:   {"C", 1, MaxFloor},
+}
+
+// HasFloor reports if events of kind have a floor.
+func (k Kind) HasFloor() bool {
+	_, ok := floorKinds[k]
+	return ok
+}
+
+// Snapshot is a snapshot of the simulator state.
+type Snapshot struct {
+	Floor    int    `json:"floor"`
+	Motor    string `json:"motor"` // UP, DOWN or OFF
+	Door     string `json:"door"`  // OPENING, OPEN, CLOSING or CLOSED
+	Stopping bool   `json:"stopping"`
+	Crashed  bool   `json:"crashed"`
+	Panel    []int  `json:"panel"` // lit panel buttons
+	Up       []int  `json:"up"`    // lit up buttons
+	Down     []int  `json:"down"`  // lit down buttons
+}
+
+// Event is an event from the simulator.
+type Event struct {
+	Kind     Kind
+	Floor    int       // For events with a floor (see Kind.HasFloor)
+	Cmd      string    // Rejected command, empty if the error isn't about a command
+	Reason   string    // Crashed, Rejected, Bye and Reconnected (missed events) reason
+	Snapshot *Snapshot // StateReceived snapshot
+}
+
+// String returns the event in the simulator protocol format (e.g. "A2").
+func (e Event) String() string {
+	if r, ok := floorKinds[e.Kind]; ok {
+		return fmt.Sprintf("%s%d", r.prefix, e.Floor)
+	}
+
+	switch e.Kind {
+	case Crashed:
+		return "CRASH " + e.Reason
+	case Reset:
+		return "RESET"
+	case Tick:
+		return "T"
+	case Rejected:
+		if e.Cmd == "" {
+			return "ERR " + e.Reason
+		}
+		return fmt.Sprintf("ERR %s: %s", e.Cmd, e.Reason)
+	case Promoted:
+		return "PROMOTED"
+	case Bye:
+		return "BYE " + e.Reason
+	case StateReceived:
+		data, _ := json.Marshal(e.Snapshot)
+		return "STATE " + string(data)
+	case Reconnected:
+		if e.Reason == "" {
+			return "RECONNECTED"
+		}
+		return "RECONNECTED " + e.Reason
+	}
+
+	return fmt.Sprintf("Event(%s)", e.Kind)
+}
+
+// ParseEvent parses an event line from the simulator (without a sequence number).
+func ParseEvent(line string) (Event, error) {
+	if word, rest, ok := strings.Cut(line, " "); ok {
+		switch word {
+		case "CRASH":
+			return Event{Kind: Crashed, Reason: rest}, nil
+		case "BYE":
+			return Event{Kind: Bye, Reason: rest}, nil
+		case "RECONNECTED":
+			return Event{Kind: Reconnected, Reason: rest}, nil
+		case "STATE":
+			var s Snapshot
+			if err := json.Unmarshal([]byte(rest), &s); err != nil {
+				return Event{}, fmt.Errorf("%q: bad state - %w", line, err)
+			}
+			return Event{Kind: StateReceived, Snapshot: &s}, nil
+		case "ERR":
+			if cmd, why, ok := strings.Cut(rest, ": "); ok {
+				return Event{Kind: Rejected, Cmd: cmd, Reason: why}, nil
+			}
+			// Not about a specific command (e.g. "ERR line too long")
+			return Event{Kind: Rejected, Reason: rest}, nil
+		}
+
+		return Event{}, fmt.Errorf("%q: unknown event", line)
+	}
+
+	switch line {
+	case "RESET":
+		return Event{Kind: Reset}, nil
+	case "T":
+		return Event{Kind: Tick}, nil
+	case "PROMOTED":
+		return Event{Kind: Promoted}, nil
+	case "RECONNECTED":
+		return Event{Kind: Reconnected}, nil
+	}
+
+	i := strings.IndexAny(line, "0123456789")
+	if i == -1 {
+		return Event{}, fmt.Errorf("%q: unknown event", line)
+	}
+
+	prefix := line[:i]
+	floor, err := strconv.Atoi(line[i:])
+	if err != nil || len(line[i:]) != 1 { // single digit floors
+		return Event{}, fmt.Errorf("%q: bad floor", line)
+	}
+
+	for kind, r := range floorKinds {
+		if r.prefix != prefix {
+			continue
+		}
+
+		if floor < r.min || floor > r.max {
+			return Event{}, fmt.Errorf("%q: floor %d out of range [%d, %d]", line, floor, r.min, r.max)
+		}
+
+		return Event{Kind: kind, Floor: floor}, nil
+	}
+
+	return Event{}, fmt.Errorf("%q: unknown event", line)
+}
@@ -0,0 +1,83 @@
+package droopy
+
+import (
+	"testing"
+)
+
+func TestParseEvent(t *testing.T) {
+	cases := []struct {
+		line string
+		evt  Event
+	}{
+		{"P3", Event{Kind: PanelPressed, Floor: 3}},
+		{"U1", Event{Kind: UpPressed, Floor: 1}},
+		{"D4", Event{Kind: DownPressed, Floor: 4}},
+		{"CP2", Event{Kind: PanelCleared, Floor: 2}},
+		{"CU3", Event{Kind: UpCleared, Floor: 3}},
+		{"CD2", Event{Kind: DownCleared, Floor: 2}},
+		{"A2", Event{Kind: Approaching, Floor: 2}},
+		{"S1", Event{Kind: Stopped, Floor: 1}},
+		{"O4", Event{Kind: DoorOpened, Floor: 4}},
+		{"C4", Event{Kind: DoorClosed, Floor: 4}},
+		{"CRASH door command while moving", Event{Kind: Crashed, Reason: "door command while moving"}},
+		{"RESET", Event{Kind: Reset}},
+		{"T", Event{Kind: Tick}},
+		{"ERR MU: rate limit exceeded", Event{Kind: Rejected, Cmd: "MU", Reason: "rate limit exceeded"}},
+		{"ERR line too long (max is 16 bytes)", Event{Kind: Rejected, Reason: "line too long (max is 16 bytes)"}},
+		{"PROMOTED", Event{Kind: Promoted}},
+		{"BYE quit", Event{Kind: Bye, Reason: "quit"}},
+		{"RECONNECTED", Event{Kind: Reconnected}},
+		{"RECONNECTED missed events", Event{Kind: Reconnected, Reason: "missed events"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.line, func(t *testing.T) {
+			evt, err := ParseEvent(tc.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if evt != tc.evt {
+				t.Fatalf("expected %+v, got %+v", tc.evt, evt)
+			}
+
+			if s := evt.String(); s != tc.line {
+				t.Fatalf("String: expected %q, got %q", tc.line, s)
+			}
+		})
+	}
+}
+
+func TestParseEvent_Error(t *testing.T) {
+	for _, line := range []string{"", "A", "A0", "A5", "U4", "D1", "A22", "A+2", "X1", "a2", "HELLO there", "P3 "} {
+		t.Run(line, func(t *testing.T) {
+			if evt, err := ParseEvent(line); err == nil {
+				t.Fatalf("expected error, got %+v", evt)
+			}
+		})
+	}
+}
+
+func TestParseEvent_State(t *testing.T) {
+	line := `STATE {"floor":2,"motor":"OFF","door":"OPEN","stopping":false,"crashed":false,"panel":[3],"up":[],"down":[4]}`
+	evt, err := ParseEvent(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if evt.Kind != StateReceived || evt.Snapshot == nil {
+		t.Fatalf("bad event: %+v", evt)
+	}
+
+	if s := evt.Snapshot; s.Floor != 2 || s.Door != "OPEN" || len(s.Panel) != 1 || s.Panel[0] != 3 {
+		t.Fatalf("bad snapshot: %+v", s)
+	}
+
+	if s := evt.String(); s != line {
+		t.Fatalf("String: expected %q, got %q", line, s)
+	}
+
+	if _, err := ParseEvent("STATE {"); err == nil {
+		t.Fatal("expected error for bad state")
+	}
+}
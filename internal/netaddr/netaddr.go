@@ -0,0 +1,35 @@
+// Package netaddr parses simulator addresses, it's shared by the client and the simulator.
+package netaddr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Split splits a simulator address to network and address.
+// addr can be "host:port", "tcp://host:port", "unix:/path/to/socket" or "unix:///path/to/socket".
+// Listener options such as "?role=observer" are an error, the caller cuts them first.
+func Split(addr string) (network, address string, err error) {
+	if strings.Contains(addr, "?") {
+		return "", "", fmt.Errorf("%q: unexpected options", addr)
+	}
+
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "unix:"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix:")
+	case strings.HasPrefix(addr, "tcp://"):
+		network, address = "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("%q: unknown network", addr)
+	default:
+		network, address = "tcp", addr
+	}
+
+	if address == "" {
+		return "", "", fmt.Errorf("%q: missing address", addr)
+	}
+
+	return network, address, nil
+}
@@ -0,0 +1,42 @@
+package netaddr
+
+import (
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"localhost:10000", "tcp", "localhost:10000"},
+		{":10000", "tcp", ":10000"},
+		{"tcp://localhost:10000", "tcp", "localhost:10000"},
+		{"unix:/tmp/droopy.sock", "unix", "/tmp/droopy.sock"},
+		{"unix:///tmp/droopy.sock", "unix", "/tmp/droopy.sock"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.addr, func(t *testing.T) {
+			network, address, err := Split(tc.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if network != tc.network || address != tc.address {
+				t.Fatalf("expected %s %s, got %s %s", tc.network, tc.address, network, address)
+			}
+		})
+	}
+}
+
+func TestSplit_Error(t *testing.T) {
+	for _, addr := range []string{"", "unix:", "udp://localhost:10000", "localhost:10000?role=observer"} {
+		t.Run(addr, func(t *testing.T) {
+			if _, _, err := Split(addr); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package sim
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+const maxAuthLine = 1024
+
+// readAuthLine reads the first line from conn.
+// It reads one byte at a time so the connection handler won't miss buffered data.
+func readAuthLine(conn net.Conn) (string, error) {
+	var buf []byte
+	b := make([]byte, 1)
+	for len(buf) < maxAuthLine {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(buf), "\r"), nil
+		}
+		buf = append(buf, b[0])
+	}
+
+	return "", errors.New("line too long")
+}
+
+// authenticate checks that the first line from conn is "AUTH <token>" and that it arrives within timeout.
+func authenticate(conn net.Conn, token string, timeout time.Duration) error {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	line, err := readAuthLine(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	cmd, arg, _ := strings.Cut(line, " ")
+	if cmd != "AUTH" {
+		return errors.New("expected AUTH")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(arg), []byte(token)) != 1 {
+		return errors.New("bad token")
+	}
+
+	return nil
+}
+
+// certIdentity returns the connection name and role from a client certificate.
+// The name is the certificate common name, the role is the first organizational unit that is a role name.
+func certIdentity(state tls.ConnectionState) (string, Role) {
+	if len(state.PeerCertificates) == 0 {
+		return "", RoleAuto
+	}
+
+	subject := state.PeerCertificates[0].Subject
+	for _, ou := range subject.OrganizationalUnit {
+		if role, err := ParseRole(ou); err == nil {
+			return subject.CommonName, role
+		}
+	}
+
+	return subject.CommonName, RoleAuto
+}
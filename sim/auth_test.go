@@ -0,0 +1,42 @@
+package sim
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	cases := []struct {
+		line string
+		ok   bool
+	}{
+		{"AUTH s3cr3t\n", true},
+		{"AUTH s3cr3t\r\n", true},
+		{"AUTH guess\n", false},
+		{"AUTH\n", false},
+		{"MU\n", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.line, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go client.Write([]byte(tc.line))
+
+			err := authenticate(server, "s3cr3t", time.Second)
+			if ok := err == nil; ok != tc.ok {
+				t.Fatalf("expected ok=%v, got %v", tc.ok, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_Timeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	if err := authenticate(server, "s3cr3t", 10*time.Millisecond); err == nil {
+		t.Fatal("expected timeout")
+	}
+}
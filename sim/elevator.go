@@ -0,0 +1,397 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MaxFloor is the top floor, floors start at 1.
+const MaxFloor = 4
+
+type MotorState byte
+
+const (
+	MotorUp MotorState = iota + 1
+	MotorDown
+	MotorOff
+)
+
+func (s MotorState) String() string {
+	switch s {
+	case MotorUp:
+		return "UP"
+	case MotorDown:
+		return "DOWN"
+	case MotorOff:
+		return "OFF"
+	}
+
+	return fmt.Sprintf("MotorState(%d)", s)
+}
+
+type DoorState byte
+
+const (
+	DoorOpening DoorState = iota + 1
+	DoorOpen
+	DoorClosing
+	DoorClosed
+)
+
+func (s DoorState) String() string {
+	switch s {
+	case DoorOpening:
+		return "OPENING"
+	case DoorOpen:
+		return "OPEN"
+	case DoorClosing:
+		return "CLOSING"
+	case DoorClosed:
+		return "CLOSED"
+	}
+
+	return fmt.Sprintf("DoorState(%d)", s)
+}
+
+// Fault is a hardware fault injected by the operator.
+type Fault byte
+
+const (
+	FaultDoorJam Fault = 1 << iota // door doesn't finish opening or closing
+)
+
+var faultNames = map[string]Fault{
+	"door-jam": FaultDoorJam,
+}
+
+// ParseFault parses a fault name such as "door-jam".
+func ParseFault(name string) (Fault, error) {
+	f, ok := faultNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown fault: %q", name)
+	}
+
+	return f, nil
+}
+
+// Elevator is the elevator state machine, it's driven by commands and ticks (see Handle).
+// The zero value is not ready for use, call Reset first.
+type Elevator struct {
+	// floors start at 1
+	panel      [MaxFloor + 1]bool // in car panel
+	up         [MaxFloor + 1]bool // up buttons on floors
+	down       [MaxFloor + 1]bool // down buttons on floors
+	floor      int                // Current floor, starts at 1
+	motor      MotorState
+	door       DoorState
+	stopping   bool
+	crashed    bool
+	crashCount int   // Total crashes this session
+	eventTime  int   // Start of event such as door opening, move ...
+	faults     Fault // Injected faults, not cleared by Reset
+}
+
+// Crashed reports if the elevator crashed, only a reset clears it.
+func (e *Elevator) Crashed() bool {
+	return e.crashed
+}
+
+// CrashCount returns the number of crashes since the elevator was created.
+func (e *Elevator) CrashCount() int {
+	return e.crashCount
+}
+
+// Faults returns the injected faults.
+func (e *Elevator) Faults() Fault {
+	return e.faults
+}
+
+// SetFaults sets the injected faults, 0 clears them.
+func (e *Elevator) SetFaults(f Fault) {
+	e.faults = f
+}
+
+func (e *Elevator) crash() {
+	if !e.crashed {
+		e.crashed = true
+		e.crashCount++
+	}
+}
+
+func (e *Elevator) Reset() {
+	for i := range e.panel {
+		e.panel[i] = false
+	}
+
+	for i := range e.up {
+		e.up[i] = false
+	}
+
+	for i := range e.down {
+		e.down[i] = false
+	}
+
+	e.floor = 1
+	e.motor = MotorOff
+	e.door = DoorClosed
+	e.crashed = false
+}
+
+// Timing in ticks, there are 10 ticks in a simulated second.
+const (
+	TicksPerFloor = 40
+	TicksPerDoor  = 20
+	ApproachTicks = 10
+)
+
+// setDoor sets door state, returns crash message.
+func (e *Elevator) setDoor(state DoorState) string {
+	switch {
+	case e.motor != MotorOff:
+		e.crash()
+		return "crash: door command while moving"
+	case e.door == DoorClosed && state == DoorOpening:
+		e.door = DoorOpening
+		e.eventTime = 0
+		return ""
+	case e.door == DoorOpen && state == DoorClosing:
+		e.door = DoorClosing
+		e.eventTime = 0
+		return ""
+	}
+
+	e.crash()
+	return fmt.Sprintf("crash: door %s in state %s", state, e.door)
+}
+
+// setMotor sets motor state, returns crash message.
+func (e *Elevator) setMotor(state MotorState) string {
+	if e.door != DoorClosed {
+		e.crash()
+		return fmt.Sprintf("crash: motor command while door %s", e.door)
+	}
+
+	if e.motor != MotorOff {
+		e.crash()
+		return fmt.Sprintln("crash: motor command while moving")
+	}
+
+	if e.motor == MotorOff && state == MotorOff {
+		e.crash()
+		return "crash: motor already off"
+	}
+
+	e.motor = state
+	e.eventTime = 0
+	return ""
+}
+
+// Failsafe stops the car at the next floor if it's moving, returns crash message.
+func (e *Elevator) Failsafe() string {
+	if e.crashed || e.stopping || e.motor == MotorOff {
+		return ""
+	}
+
+	return e.Handle("S")
+}
+
+func nextFloor(floor int, motor MotorState) int {
+	if motor == MotorUp {
+		return floor + 1
+	}
+
+	return floor - 1
+}
+
+func cmdFloor(cmd string) int {
+	return int(cmd[len(cmd)-1] - '0')
+}
+
+// Handle handles a command, returns an event to report (empty string if no event).
+func (e *Elevator) Handle(cmd string) string {
+	if cmd == "R" { // Reset
+		e.Reset()
+		return ""
+	}
+
+	// Ignore commands when crashed
+	if e.crashed {
+		return ""
+	}
+
+	switch cmd {
+	case "P1", "P2", "P3", "P4":
+		e.panel[cmdFloor(cmd)] = true
+		return cmd
+	case "CP1", "CP2", "CP3", "CP4":
+		e.panel[cmdFloor(cmd)] = false
+		return cmd
+	case "U1", "U2", "U3":
+		e.up[cmdFloor(cmd)] = true
+		return cmd
+	case "CU1", "CU2", "CU3":
+		e.up[cmdFloor(cmd)] = false
+		return cmd
+	case "D2", "D3", "D4":
+		e.down[cmdFloor(cmd)] = true
+		return cmd
+	case "CD2", "CD3", "CD4":
+		e.down[cmdFloor(cmd)] = false
+		return cmd
+	case "DO":
+		return e.setDoor(DoorOpening)
+	case "DC":
+		return e.setDoor(DoorClosing)
+	case "MU":
+		return e.setMotor(MotorUp)
+	case "MD":
+		return e.setMotor(MotorDown)
+	case "S":
+		if e.stopping {
+			e.crash()
+			return "crash: already stopping"
+		}
+
+		if e.motor == MotorOff {
+			e.crash()
+			return "crash: not moving"
+		}
+
+		e.stopping = true
+	case "T":
+		e.eventTime++
+
+		if e.door == DoorOpening || e.door == DoorClosing {
+			if e.eventTime <= TicksPerDoor || e.faults&FaultDoorJam != 0 {
+				return ""
+			}
+
+			var evt string
+			if e.door == DoorOpening {
+				e.door = DoorOpen
+				evt = "O"
+			} else {
+				e.door = DoorClosed
+				evt = "C"
+			}
+			e.eventTime = 0
+			return fmt.Sprintf("%s%d", evt, e.floor)
+		}
+
+		if e.motor == MotorUp || e.motor == MotorDown {
+			if e.eventTime == TicksPerFloor {
+				floor := nextFloor(e.floor, e.motor)
+				if floor > MaxFloor {
+					e.crash()
+					return "crash: out of the roof"
+				}
+
+				if floor < 1 {
+					e.crash()
+					return "crash: into the basement"
+				}
+
+				e.floor = floor
+				e.eventTime = 0
+
+				if e.stopping {
+					e.stopping = false
+					e.motor = MotorOff
+					return fmt.Sprintf("S%d", e.floor)
+				}
+			}
+
+			if e.eventTime == TicksPerFloor-ApproachTicks {
+				floor := nextFloor(e.floor, e.motor)
+				if floor >= 1 && floor <= MaxFloor {
+					return fmt.Sprintf("A%d", floor)
+				}
+			}
+		}
+	default:
+		e.crash()
+		return fmt.Sprintf("crash: unknown command - %q", cmd)
+	}
+
+	return ""
+}
+
+func (e *Elevator) statusStr() string {
+	if e.crashed {
+		return "CRASH"
+	}
+
+	if e.stopping {
+		return "STOPPING"
+	}
+
+	if e.motor == MotorUp || e.motor == MotorDown {
+		return e.motor.String()
+	}
+
+	if e.door == DoorClosed || e.door == DoorClosing || e.door == DoorOpen || e.door == DoorOpening {
+		return e.door.String()
+	}
+
+	panic(fmt.Sprintf("unknown state: %#v", e))
+}
+
+func buttonsStr(buttons []bool) string {
+	buf := make([]byte, len(buttons)-1) // 0 is a placeholder
+	for i, v := range buttons[1:] {
+		if v {
+			buf[i] = '0' + byte(i+1)
+		} else {
+			buf[i] = '-'
+		}
+	}
+
+	return string(buf)
+}
+
+func litFloors(buttons []bool) []int {
+	floors := []int{}
+	for i, v := range buttons[1:] {
+		if v {
+			floors = append(floors, i+1)
+		}
+	}
+
+	return floors
+}
+
+// State returns a snapshot of the elevator state.
+func (e *Elevator) State() State {
+	return State{
+		Floor:    e.floor,
+		Motor:    e.motor.String(),
+		Door:     e.door.String(),
+		Stopping: e.stopping,
+		Crashed:  e.crashed,
+		Panel:    litFloors(e.panel[:]),
+		Up:       litFloors(e.up[:]),
+		Down:     litFloors(e.down[:]),
+	}
+}
+
+func (e *Elevator) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "FLOOR %d", e.floor)
+	fmt.Fprintf(&buf, "| %-8s", e.statusStr())
+	fmt.Fprintf(&buf, "| P:%s", buttonsStr(e.panel[:]))
+	fmt.Fprintf(&buf, "| U:%s", buttonsStr(e.up[:]))
+	fmt.Fprintf(&buf, "| D:%s", buttonsStr(e.down[:]))
+	return buf.String()
+}
+
+// State is a JSON snapshot of the elevator.
+type State struct {
+	Floor    int    `json:"floor"`
+	Motor    string `json:"motor"`
+	Door     string `json:"door"`
+	Stopping bool   `json:"stopping"`
+	Crashed  bool   `json:"crashed"`
+	Panel    []int  `json:"panel"` // lit panel buttons
+	Up       []int  `json:"up"`    // lit up buttons
+	Down     []int  `json:"down"`  // lit down buttons
+}
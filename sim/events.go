@@ -0,0 +1,94 @@
+package sim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EventClass is a bit set of event classes, used for subscriptions.
+type EventClass uint8
+
+const (
+	ClassButtons  EventClass = 1 << iota // Pn, Un, Dn, CPn, CUn, CDn
+	ClassApproach                        // An
+	ClassStop                            // Sn
+	ClassDoor                            // On, Cn
+	ClassCrash                           // CRASH <reason>, RESET
+	ClassTick                            // T
+
+	ClassAll = ClassButtons | ClassApproach | ClassStop | ClassDoor | ClassCrash | ClassTick
+	// ClassDefault is "everything" connections got before subscriptions: crash, reset and tick events
+	// were added with subscriptions and existing controllers don't expect them, so they are opt-in.
+	ClassDefault = ClassButtons | ClassApproach | ClassStop | ClassDoor
+)
+
+var classNames = []struct {
+	class EventClass
+	name  string
+}{
+	{ClassButtons, "buttons"},
+	{ClassApproach, "approach"},
+	{ClassStop, "stop"},
+	{ClassDoor, "door"},
+	{ClassCrash, "crash"},
+	{ClassTick, "tick"},
+}
+
+func (c EventClass) String() string {
+	var names []string
+	for _, cn := range classNames {
+		if c&cn.class != 0 {
+			names = append(names, cn.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+// ParseClasses parses class names such as "door" or "all" into a class set.
+func ParseClasses(names []string) (EventClass, error) {
+	var c EventClass
+	for _, name := range names {
+		if name == "all" {
+			c |= ClassAll
+			continue
+		}
+
+		found := false
+		for _, cn := range classNames {
+			if name == cn.name {
+				c |= cn.class
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, fmt.Errorf("unknown event class: %q", name)
+		}
+	}
+
+	return c, nil
+}
+
+// ClassOf returns the class of evt.
+func ClassOf(evt string) EventClass {
+	switch {
+	case evt == "T":
+		return ClassTick
+	case evt == "RESET" || strings.HasPrefix(evt, "CRASH"):
+		return ClassCrash
+	case len(evt) == 2 && evt[0] == 'A':
+		return ClassApproach
+	case len(evt) == 2 && evt[0] == 'S':
+		return ClassStop
+	case len(evt) == 2 && (evt[0] == 'O' || evt[0] == 'C'):
+		return ClassDoor
+	}
+
+	return ClassButtons
+}
@@ -0,0 +1,56 @@
+package sim
+
+import (
+	"testing"
+)
+
+func TestEventClass(t *testing.T) {
+	cases := []struct {
+		evt   string
+		class EventClass
+	}{
+		{"P1", ClassButtons},
+		{"U2", ClassButtons},
+		{"CD3", ClassButtons},
+		{"CP4", ClassButtons},
+		{"A2", ClassApproach},
+		{"S3", ClassStop},
+		{"O1", ClassDoor},
+		{"C4", ClassDoor},
+		{"CRASH door command while moving", ClassCrash},
+		{"RESET", ClassCrash},
+		{"T", ClassTick},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.evt, func(t *testing.T) {
+			if class := ClassOf(tc.evt); class != tc.class {
+				t.Fatalf("expected %s, got %s", tc.class, class)
+			}
+		})
+	}
+}
+
+func TestParseClasses(t *testing.T) {
+	c, err := ParseClasses([]string{"door", "stop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c != ClassDoor|ClassStop {
+		t.Fatalf("bad classes: %s", c)
+	}
+
+	c, err = ParseClasses([]string{"all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.String() != "buttons,approach,stop,door,crash,tick" {
+		t.Fatalf("bad classes: %s", c)
+	}
+
+	if _, err := ParseClasses([]string{"doors"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
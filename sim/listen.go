@@ -0,0 +1,95 @@
+package sim
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/353solutions/droopy/internal/netaddr"
+)
+
+// listenSpec is a listener address such as ":10000", "unix:/tmp/droopy.sock" or "tcp://localhost:10001?role=observer".
+type listenSpec struct {
+	network string
+	addr    string
+	role    Role // initial role of connections
+}
+
+func parseListen(s string) (listenSpec, error) {
+	addr, query, _ := strings.Cut(s, "?")
+	network, address, err := netaddr.Split(addr)
+	if err != nil {
+		return listenSpec{}, err
+	}
+
+	if network == "tcp" {
+		if err := validateAddr(address); err != nil {
+			return listenSpec{}, err
+		}
+	}
+
+	spec := listenSpec{
+		network: network,
+		addr:    address,
+		role:    RoleAuto,
+	}
+
+	if query == "" {
+		return spec, nil
+	}
+
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return listenSpec{}, fmt.Errorf("%q: %w", s, err)
+	}
+
+	for key := range q {
+		if key != "role" {
+			return listenSpec{}, fmt.Errorf("%q: unknown option: %q", s, key)
+		}
+	}
+
+	spec.role, err = ParseRole(q.Get("role"))
+	if err != nil {
+		return listenSpec{}, fmt.Errorf("%q: %w", s, err)
+	}
+
+	return spec, nil
+}
+
+// validateAddr checks a TCP address such as "localhost:10000".
+func validateAddr(addr string) error {
+	_, err := net.ResolveTCPAddr("tcp", addr)
+	return err
+}
+
+func (s listenSpec) String() string {
+	return s.network + ":" + s.addr
+}
+
+func (s listenSpec) Listen() (net.Listener, error) {
+	if s.network == "unix" {
+		removeStaleSocket(s.addr)
+	}
+
+	return net.Listen(s.network, s.addr)
+}
+
+// removeStaleSocket removes a Unix socket file left by a previous run.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	// Someone is listening on it
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return
+	}
+
+	// Listen reports the error if it's still there
+	os.Remove(path)
+}
@@ -0,0 +1,47 @@
+package sim
+
+import (
+	"testing"
+)
+
+func TestParseListen(t *testing.T) {
+	cases := []struct {
+		addr string
+		spec listenSpec
+	}{
+		{":10000", listenSpec{"tcp", ":10000", RoleAuto}},
+		{"tcp://localhost:10001?role=observer", listenSpec{"tcp", "localhost:10001", RoleObserver}},
+		{"unix:/tmp/droopy.sock", listenSpec{"unix", "/tmp/droopy.sock", RoleAuto}},
+		{"unix:///tmp/droopy.sock?role=passenger", listenSpec{"unix", "/tmp/droopy.sock", RolePassenger}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.addr, func(t *testing.T) {
+			spec, err := parseListen(tc.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if spec != tc.spec {
+				t.Fatalf("expected %+v, got %+v", tc.spec, spec)
+			}
+		})
+	}
+}
+
+func TestParseListen_Error(t *testing.T) {
+	addrs := []string{
+		"tcp://localhost:10001?role=admin",
+		"tcp://localhost:10001?color=red",
+		"localhost",
+		"udp://localhost:10000",
+	}
+
+	for _, addr := range addrs {
+		t.Run(addr, func(t *testing.T) {
+			if _, err := parseListen(addr); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,899 @@
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Role is the role of a connection.
+type Role byte
+
+const (
+	RoleAuto       Role = iota // Controller on first command if there's none, otherwise observer
+	RoleController             // Can send any command
+	RoleObserver               // Gets events, can't send commands
+	RolePassenger              // Can only press buttons
+	RoleStandby                // Observer that becomes the controller when the current one leaves
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleAuto:
+		return "auto"
+	case RoleController:
+		return "controller"
+	case RoleObserver:
+		return "observer"
+	case RolePassenger:
+		return "passenger"
+	case RoleStandby:
+		return "standby"
+	}
+
+	return fmt.Sprintf("Role(%d)", r)
+}
+
+// ParseRole parses a role name such as "observer".
+func ParseRole(s string) (Role, error) {
+	for _, r := range []Role{RoleAuto, RoleController, RoleObserver, RolePassenger, RoleStandby} {
+		if s == r.String() {
+			return r, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown role: %q", s)
+}
+
+// Allowed returns nil if a connection with role r can send cmd.
+func (r Role) Allowed(cmd string) error {
+	switch r {
+	case RoleController:
+		return nil
+	case RolePassenger:
+		if IsButton(cmd) {
+			return nil
+		}
+		return errors.New("passenger can only press buttons")
+	}
+
+	return fmt.Errorf("%s can't send commands", r)
+}
+
+// SlowPolicy is what to do when a connection outbound queue is full.
+type SlowPolicy byte
+
+const (
+	SlowBlock      SlowPolicy = iota + 1 // Wait until there's room in the queue
+	SlowDropOldest                       // Drop the oldest event in the queue
+	SlowDisconnect                       // Drop the event and disconnect
+)
+
+func (s SlowPolicy) String() string {
+	switch s {
+	case SlowBlock:
+		return "block"
+	case SlowDropOldest:
+		return "drop-oldest"
+	case SlowDisconnect:
+		return "disconnect"
+	}
+
+	return fmt.Sprintf("SlowPolicy(%d)", s)
+}
+
+// ParseSlowPolicy parses a slow consumer policy name such as "drop-oldest".
+func ParseSlowPolicy(s string) (SlowPolicy, error) {
+	for _, p := range []SlowPolicy{SlowBlock, SlowDropOldest, SlowDisconnect} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown slow consumer policy: %q", s)
+}
+
+// connState is the pool state of a connection.
+type connState struct {
+	name    string
+	role    Role
+	out     *queue        // outbound queue, drained by writer
+	flushed chan struct{} // closed when writer is done
+	dropped int           // events dropped due to a full queue
+	closing bool          // disconnected due to SlowDisconnect
+	seq     bool          // add sequence numbers to events
+	subs    EventClass    // event classes to send
+
+	pingSent  time.Time // zero if there's no outstanding PING
+	unhealthy bool      // didn't answer PING in time
+
+	connected time.Time
+	commands  int // elevator commands sent by the connection
+	events    int // events sent to the connection, ticks excluded
+}
+
+// ConnInfo is connection metadata, see Sim.Conns.
+type ConnInfo struct {
+	Addr      string    `json:"addr"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Connected time.Time `json:"connected"`
+	Commands  int       `json:"commands"`
+	Events    int       `json:"events"`
+}
+
+// seqEvent is an event with a sequence number.
+type seqEvent struct {
+	seq uint64
+	msg string
+}
+
+func (e seqEvent) String() string {
+	return fmt.Sprintf("%s #%d", e.msg, e.seq)
+}
+
+var (
+	errResumeGap = errors.New("gap too large")
+	errNameTaken = errors.New("name taken")
+)
+
+// connPool is the pool of connected clients.
+// Every connection has a bounded outbound queue and a writer goroutine, messages to a connection are sent in order.
+// With SlowBlock, senders wait for room in the queue after releasing the pool lock,
+// a slow connection blocks its senders but not the rest of the pool.
+// Taps (see Sim.Events) get events like connections, with the same queues and policy.
+type connPool struct {
+	onPromote func(conn net.Conn)              // called when a standby connection becomes the controller
+	logf      func(format string, args ...any) // logs slow and unhealthy connections
+	greeting  string                           // first line to new connections (none if empty)
+
+	queueSize   int
+	policy      SlowPolicy
+	historySize int
+
+	mu      sync.Mutex
+	closed  bool
+	conns   map[net.Conn]*connState
+	taps    map[*queue]*connState
+	standby []net.Conn // in promotion order
+	dropped int        // total dropped events
+	errors  int        // connection errors
+	limited int        // rate limited commands
+	seq     uint64     // last event sequence number
+	history []seqEvent // last historySize events
+}
+
+func newConnPool(queueSize int, policy SlowPolicy, historySize int) *connPool {
+	return &connPool{
+		queueSize:   queueSize,
+		policy:      policy,
+		historySize: historySize,
+		conns:       make(map[net.Conn]*connState),
+		taps:        make(map[*queue]*connState),
+		logf:        func(string, ...any) {},
+	}
+}
+
+// Add adds conn to the pool with RoleAuto, the greeting is the first line it gets.
+func (p *connPool) Add(conn net.Conn) {
+	cs := connState{
+		role:      RoleAuto,
+		out:       newQueue(),
+		flushed:   make(chan struct{}),
+		subs:      ClassDefault,
+		connected: time.Now(),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		_ = conn.Close()
+		return
+	}
+
+	if p.greeting != "" {
+		cs.out.push(p.greeting)
+	}
+	p.conns[conn] = &cs
+	go writer(conn, cs.out, cs.flushed)
+}
+
+// Close sends msg to all connections, waits up to timeout for their queues to flush and closes them.
+func (p *connPool) Close(msg string, timeout time.Duration) {
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.conns = make(map[net.Conn]*connState)
+	p.standby = nil
+	for conn, cs := range conns {
+		p.enqueue(conn, cs, msg)
+		cs.out.close()
+	}
+	for q := range p.taps {
+		q.close()
+	}
+	p.taps = make(map[*queue]*connState)
+	p.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for conn, cs := range conns {
+		select {
+		case <-cs.flushed:
+		case <-deadline:
+			p.logf("%s - timeout flushing", conn.RemoteAddr())
+		}
+		_ = conn.Close()
+	}
+}
+
+// Tap returns a queue that gets the events in classes, as a connection would.
+// The queue is closed when the pool is closed or, with SlowDisconnect, when it's full.
+func (p *connPool) Tap(classes EventClass) *queue {
+	q := newQueue()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		q.close()
+		return q
+	}
+
+	p.taps[q] = &connState{out: q, subs: classes}
+	return q
+}
+
+// Untap removes q from the pool and discards its events.
+func (p *connPool) Untap(q *queue) {
+	p.mu.Lock()
+	delete(p.taps, q)
+	p.mu.Unlock()
+
+	q.discard()
+}
+
+// Remove removes conn from the pool, if conn is the controller the first standby is promoted.
+func (p *connPool) Remove(conn net.Conn) {
+	p.mu.Lock()
+	if cs, ok := p.conns[conn]; ok {
+		p.setRole(conn, RoleObserver) // leave standby queue
+		delete(p.conns, conn)
+		cs.out.close()
+	}
+	promoted := p.promote()
+	p.mu.Unlock()
+
+	p.notify(promoted)
+}
+
+// Kick disconnects the connection with name (or remote address) target after sending it msg.
+// The connection gets up to timeout to flush its queue.
+func (p *connPool) Kick(target, msg string, timeout time.Duration) error {
+	p.mu.Lock()
+	var (
+		conn net.Conn
+		cs   *connState
+	)
+	for c, s := range p.conns {
+		if s.name == target || c.RemoteAddr().String() == target {
+			conn, cs = c, s
+			break
+		}
+	}
+
+	if conn == nil {
+		p.mu.Unlock()
+		return fmt.Errorf("%q: no such connection", target)
+	}
+
+	p.enqueue(conn, cs, msg)
+	p.setRole(conn, RoleObserver) // leave standby queue
+	delete(p.conns, conn)
+	cs.out.close()
+	promoted := p.promote()
+	p.mu.Unlock()
+
+	p.notify(promoted)
+
+	go func() {
+		select {
+		case <-cs.flushed:
+		case <-time.After(timeout):
+			p.logf("%s - timeout flushing", conn.RemoteAddr())
+		}
+		_ = conn.Close()
+	}()
+
+	return nil
+}
+
+func (p *connPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Dropped returns the total number of events dropped due to slow connections.
+func (p *connPool) Dropped() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// CountError counts a connection error.
+func (p *connPool) CountError() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errors++
+}
+
+// Errors returns the number of connection errors.
+func (p *connPool) Errors() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.errors
+}
+
+// CountRateLimited counts a command rejected by the rate limit.
+func (p *connPool) CountRateLimited() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limited++
+}
+
+// RateLimited returns the number of commands rejected by the rate limit.
+func (p *connPool) RateLimited() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.limited
+}
+
+// controller returns the controller connection, must be called with p.mu held.
+func (p *connPool) controller() net.Conn {
+	for conn, cs := range p.conns {
+		if cs.role == RoleController {
+			return conn
+		}
+	}
+
+	return nil
+}
+
+// setRole sets the role of conn and keeps the standby queue in order, must be called with p.mu held.
+func (p *connPool) setRole(conn net.Conn, role Role) {
+	cs := p.conns[conn]
+	switch {
+	case cs.role == RoleStandby && role != RoleStandby:
+		for i, c := range p.standby {
+			if c == conn {
+				p.standby = append(p.standby[:i], p.standby[i+1:]...)
+				break
+			}
+		}
+	case cs.role != RoleStandby && role == RoleStandby:
+		p.standby = append(p.standby, conn)
+	}
+
+	cs.role = role
+}
+
+// promote promotes the first standby if there's no controller, must be called with p.mu held.
+// It returns the promoted connection or nil.
+func (p *connPool) promote() net.Conn {
+	if len(p.standby) == 0 || p.controller() != nil {
+		return nil
+	}
+
+	conn := p.standby[0]
+	p.setRole(conn, RoleController)
+	return conn
+}
+
+func (p *connPool) notify(promoted net.Conn) {
+	if promoted != nil && p.onPromote != nil {
+		p.onPromote(promoted)
+	}
+}
+
+// SetName sets the name of conn, names are unique.
+func (p *connPool) SetName(conn net.Conn, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cs, ok := p.conns[conn]
+	if !ok {
+		return fmt.Errorf("unknown connection: %s", conn.RemoteAddr())
+	}
+
+	for c, other := range p.conns {
+		if c != conn && other.name == name {
+			return fmt.Errorf("%q: %w", name, errNameTaken)
+		}
+	}
+
+	cs.name = name
+	return nil
+}
+
+// Name returns the name of conn.
+func (p *connPool) Name(conn net.Conn) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cs, ok := p.conns[conn]; ok {
+		return cs.name
+	}
+	return ""
+}
+
+// CountCommand counts an elevator command sent by conn.
+func (p *connPool) CountCommand(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cs, ok := p.conns[conn]; ok {
+		cs.commands++
+	}
+}
+
+// Conns returns the metadata of all connections, oldest first.
+func (p *connPool) Conns() []ConnInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := make([]ConnInfo, 0, len(p.conns))
+	for conn, cs := range p.conns {
+		ci := ConnInfo{
+			Addr:      conn.RemoteAddr().String(),
+			Name:      cs.name,
+			Role:      cs.role.String(),
+			Connected: cs.connected,
+			Commands:  cs.commands,
+			Events:    cs.events,
+		}
+		conns = append(conns, ci)
+	}
+
+	slices.SortFunc(conns, func(a, b ConnInfo) int {
+		return a.Connected.Compare(b.Connected)
+	})
+	return conns
+}
+
+// Role returns the role of conn.
+func (p *connPool) Role(conn net.Conn) Role {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cs, ok := p.conns[conn]
+	if !ok {
+		return RoleAuto
+	}
+	return cs.role
+}
+
+// Claim resolves RoleAuto for conn: it becomes the controller if there's none,
+// otherwise a standby. Claim returns the (possibly new) role of conn.
+func (p *connPool) Claim(conn net.Conn) Role {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cs, ok := p.conns[conn]
+	if !ok {
+		return RoleAuto
+	}
+
+	if cs.role != RoleAuto {
+		return cs.role
+	}
+
+	role := RoleController
+	if p.controller() != nil {
+		role = RoleStandby
+	}
+	p.setRole(conn, role)
+	return role
+}
+
+// SetRole sets the role of conn and returns the new role.
+// There's only one controller, asking for the controller role when it's taken makes conn a standby.
+func (p *connPool) SetRole(conn net.Conn, role Role) (Role, error) {
+	p.mu.Lock()
+	if _, ok := p.conns[conn]; !ok {
+		p.mu.Unlock()
+		return 0, fmt.Errorf("unknown connection: %s", conn.RemoteAddr())
+	}
+
+	if ctrl := p.controller(); role == RoleController && ctrl != nil && ctrl != conn {
+		role = RoleStandby
+	}
+
+	p.setRole(conn, role)
+	promoted := p.promote()
+	if promoted == conn {
+		role = RoleController
+	}
+	p.mu.Unlock()
+
+	p.notify(promoted)
+	return role, nil
+}
+
+func write(c net.Conn, msg string) error {
+	c.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	_, err := fmt.Fprintf(c, "%s\n", msg)
+	c.SetWriteDeadline(time.Time{})
+	return err
+}
+
+// writer writes messages from out to conn until out is closed, then it closes flushed.
+// On error the connection is closed, its handler removes it from the pool.
+func writer(conn net.Conn, out *queue, flushed chan<- struct{}) {
+	defer close(flushed)
+	// Discard the rest so blocked senders won't get stuck
+	defer out.discard()
+
+	for {
+		msg, ok := out.pop()
+		if !ok {
+			return
+		}
+
+		if err := write(conn, msg); err != nil {
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// enqueue adds msg to the outbound queue of cs according to the pool policy, must be called with p.mu held.
+// It returns false if msg was not queued (the connection is closing or too slow).
+// With SlowBlock the queue can grow past its size, call wait after releasing p.mu.
+// conn is nil for taps, a slow tap is closed instead of disconnected.
+func (p *connPool) enqueue(conn net.Conn, cs *connState, msg string) bool {
+	if cs.closing {
+		return false
+	}
+
+	switch p.policy {
+	case SlowDropOldest:
+		if cs.out.pushDropOldest(msg, p.queueSize) {
+			cs.dropped++
+			p.dropped++
+		}
+	case SlowDisconnect:
+		if !cs.out.tryPush(msg, p.queueSize) {
+			cs.dropped++
+			p.dropped++
+			cs.closing = true
+			if conn == nil {
+				cs.out.close()
+				return false
+			}
+
+			p.logf("%s too slow, disconnecting", conn.RemoteAddr())
+			_ = conn.Close()
+			return false
+		}
+	default:
+		cs.out.push(msg)
+	}
+
+	return true
+}
+
+// wait waits until there's room in the queues with SlowBlock, it must be called without p.mu held.
+func (p *connPool) wait(queues ...*queue) {
+	if p.policy != SlowBlock {
+		return
+	}
+
+	for _, q := range queues {
+		q.wait(p.queueSize)
+	}
+}
+
+// Send sends msg to a single connection.
+func (p *connPool) Send(conn net.Conn, msg string) {
+	p.mu.Lock()
+	cs, ok := p.conns[conn]
+	if ok {
+		p.enqueue(conn, cs, msg)
+	}
+	p.mu.Unlock()
+
+	if ok {
+		p.wait(cs.out)
+	}
+}
+
+// Heartbeat sends PING to connections. A connection that didn't answer the previous PING within timeout is marked unhealthy.
+func (p *connPool) Heartbeat(now time.Time, timeout time.Duration) {
+	var queued []*queue
+	defer func() { p.wait(queued...) }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for conn, cs := range p.conns {
+		if !cs.pingSent.IsZero() {
+			if !cs.unhealthy && now.Sub(cs.pingSent) > timeout {
+				p.logf("%s missed heartbeat", conn.RemoteAddr())
+				cs.unhealthy = true
+			}
+			continue
+		}
+
+		cs.pingSent = now
+		if p.enqueue(conn, cs, "PING") {
+			queued = append(queued, cs.out)
+		}
+	}
+}
+
+// Pong marks conn as healthy.
+func (p *connPool) Pong(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cs, ok := p.conns[conn]; ok {
+		cs.pingSent = time.Time{}
+		cs.unhealthy = false
+	}
+}
+
+// UnhealthyController reports if there's a controller that missed a heartbeat.
+func (p *connPool) UnhealthyController() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn := p.controller(); conn != nil {
+		return p.conns[conn].unhealthy
+	}
+
+	return false
+}
+
+// Subscribe adds (or removes if sub is false) classes from the event classes sent to conn.
+// It returns the classes conn is subscribed to.
+func (p *connPool) Subscribe(conn net.Conn, classes EventClass, sub bool) EventClass {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cs, ok := p.conns[conn]
+	if !ok {
+		return 0
+	}
+
+	if sub {
+		cs.subs |= classes
+	} else {
+		cs.subs &^= classes
+	}
+
+	return cs.subs
+}
+
+// Broadcast sends msg to all connections and taps subscribed to its class.
+// Events other than ticks are recorded in the history.
+func (p *connPool) Broadcast(msg string) {
+	var queued []*queue
+	defer func() { p.wait(queued...) }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	class := ClassOf(msg)
+	for _, cs := range p.taps {
+		if cs.subs&class != 0 && p.enqueue(nil, cs, msg) {
+			queued = append(queued, cs.out)
+		}
+	}
+
+	if class == ClassTick {
+		for conn, cs := range p.conns {
+			if cs.subs&class != 0 && p.enqueue(conn, cs, msg) {
+				queued = append(queued, cs.out)
+			}
+		}
+		return
+	}
+
+	p.seq++
+	evt := seqEvent{p.seq, msg}
+	p.history = append(p.history, evt)
+	if len(p.history) > p.historySize {
+		p.history = p.history[len(p.history)-p.historySize:]
+	}
+
+	for conn, cs := range p.conns {
+		if cs.subs&class == 0 {
+			continue
+		}
+
+		out := msg
+		if cs.seq {
+			out = evt.String()
+		}
+
+		if p.enqueue(conn, cs, out) {
+			cs.events++
+			queued = append(queued, cs.out)
+		}
+	}
+}
+
+// EnableSeq turns on sequence numbers for conn, it sends "OK SEQ <last>" to conn.
+func (p *connPool) EnableSeq(conn net.Conn) {
+	p.mu.Lock()
+	cs, ok := p.conns[conn]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+
+	cs.seq = true
+	p.enqueue(conn, cs, fmt.Sprintf("OK SEQ %d", p.seq))
+	p.mu.Unlock()
+
+	p.wait(cs.out)
+}
+
+// Resume turns on sequence numbers for conn and replays the events after seq.
+// It sends "OK RESUME <last>" to conn before the replay.
+// Resume fails if some of the events after seq are no longer in the history.
+func (p *connPool) Resume(conn net.Conn, seq uint64) error {
+	p.mu.Lock()
+	cs, ok := p.conns[conn]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("unknown connection: %s", conn.RemoteAddr())
+	}
+
+	err := p.resume(conn, cs, seq)
+	p.mu.Unlock()
+
+	p.wait(cs.out)
+	return err
+}
+
+// resume replays the events after seq to conn, must be called with p.mu held.
+func (p *connPool) resume(conn net.Conn, cs *connState, seq uint64) error {
+	if seq > p.seq {
+		return fmt.Errorf("%d is after last event (%d)", seq, p.seq)
+	}
+
+	oldest := p.seq + 1
+	if len(p.history) > 0 {
+		oldest = p.history[0].seq
+	}
+
+	if seq+1 < oldest {
+		return fmt.Errorf("%w: oldest event is %d", errResumeGap, oldest)
+	}
+
+	cs.seq = true
+	p.enqueue(conn, cs, fmt.Sprintf("OK RESUME %d", p.seq))
+	for _, evt := range p.history {
+		if evt.seq > seq && cs.subs&ClassOf(evt.msg) != 0 {
+			if p.enqueue(conn, cs, evt.String()) {
+				cs.events++
+			}
+		}
+	}
+
+	return nil
+}
+
+// queue is the outbound message queue of a connection.
+type queue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond // signaled when msgs changes
+	msgs      []string
+	closed    bool // no more messages, pop returns false once msgs is empty
+	discarded bool // the writer is gone, messages are dropped
+}
+
+func newQueue() *queue {
+	var q queue
+	q.cond = sync.NewCond(&q.mu)
+	return &q
+}
+
+func (q *queue) push(msg string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.add(msg)
+}
+
+// tryPush pushes msg if the queue has less than size messages, it returns false if the queue is full.
+func (q *queue) tryPush(msg string, size int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.msgs) >= size {
+		return false
+	}
+
+	q.add(msg)
+	return true
+}
+
+// pushDropOldest pushes msg, dropping the oldest message if the queue has size messages.
+// It returns true if a message was dropped.
+func (q *queue) pushDropOldest(msg string, size int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := len(q.msgs) >= size
+	if dropped {
+		q.msgs = q.msgs[1:]
+	}
+
+	q.add(msg)
+	return dropped
+}
+
+// add adds msg to the queue, q.mu must be held.
+func (q *queue) add(msg string) {
+	if q.closed || q.discarded {
+		return
+	}
+
+	q.msgs = append(q.msgs, msg)
+	q.cond.Broadcast()
+}
+
+// pop returns the oldest message, blocking until there's one.
+// It returns false when the queue is closed and empty, or discarded.
+func (q *queue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.msgs) == 0 && !q.closed && !q.discarded {
+		q.cond.Wait()
+	}
+
+	if len(q.msgs) == 0 {
+		return "", false
+	}
+
+	msg := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	q.cond.Broadcast()
+	return msg, true
+}
+
+// close marks the end of the queue, the messages already in it are still popped.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// discard drops the queued messages and the ones pushed later.
+func (q *queue) discard() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.discarded = true
+	q.msgs = nil
+	q.cond.Broadcast()
+}
+
+// wait blocks until the queue has at most size messages.
+func (q *queue) wait(size int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.msgs) > size {
+		q.cond.Wait()
+	}
+}
@@ -0,0 +1,319 @@
+package sim
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRole_Allowed(t *testing.T) {
+	cases := []struct {
+		role    Role
+		cmd     string
+		allowed bool
+	}{
+		{RoleController, "MU", true},
+		{RoleController, "P3", true},
+		{RoleObserver, "MU", false},
+		{RoleObserver, "P3", false},
+		{RolePassenger, "P3", true},
+		{RolePassenger, "U1", true},
+		{RolePassenger, "CP3", false},
+		{RolePassenger, "DO", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.role.String()+":"+tc.cmd, func(t *testing.T) {
+			err := tc.role.Allowed(tc.cmd)
+			if allowed := err == nil; allowed != tc.allowed {
+				t.Fatalf("expected allowed=%v, got %v", tc.allowed, err)
+			}
+		})
+	}
+}
+
+func TestConnPool_Claim(t *testing.T) {
+	p := newConnPool(16, SlowBlock, 16)
+	c1, _ := net.Pipe()
+	c2, _ := net.Pipe()
+	p.Add(c1)
+	p.Add(c2)
+
+	if r := p.Role(c1); r != RoleAuto {
+		t.Fatalf("expected %s, got %s", RoleAuto, r)
+	}
+
+	if r := p.Claim(c1); r != RoleController {
+		t.Fatalf("expected %s, got %s", RoleController, r)
+	}
+
+	if r := p.Claim(c2); r != RoleStandby {
+		t.Fatalf("expected %s, got %s", RoleStandby, r)
+	}
+}
+
+func TestConnPool_Promote(t *testing.T) {
+	p := newConnPool(16, SlowBlock, 16)
+	var promoted []net.Conn
+	p.onPromote = func(conn net.Conn) {
+		promoted = append(promoted, conn)
+	}
+
+	c1, _ := net.Pipe()
+	c2, _ := net.Pipe()
+	c3, _ := net.Pipe()
+	for _, c := range []net.Conn{c1, c2, c3} {
+		p.Add(c)
+		if _, err := p.SetRole(c, RoleController); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r := p.Role(c2); r != RoleStandby {
+		t.Fatalf("c2: expected %s, got %s", RoleStandby, r)
+	}
+
+	p.Remove(c1)
+	if r := p.Role(c2); r != RoleController {
+		t.Fatalf("c2: expected %s, got %s", RoleController, r)
+	}
+
+	if _, err := p.SetRole(c2, RoleObserver); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := p.Role(c3); r != RoleController {
+		t.Fatalf("c3: expected %s, got %s", RoleController, r)
+	}
+
+	if len(promoted) != 2 || promoted[0] != c2 || promoted[1] != c3 {
+		t.Fatalf("bad promotions: %v", promoted)
+	}
+}
+
+func readLines(conn net.Conn) []string {
+	var lines []string
+	s := bufio.NewScanner(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if !s.Scan() {
+			return lines
+		}
+		lines = append(lines, s.Text())
+	}
+}
+
+func TestConnPool_SlowPolicy(t *testing.T) {
+	msgs := []string{"P1", "P2", "P3", "P4", "U1"}
+
+	t.Run("block", func(t *testing.T) {
+		p := newConnPool(1, SlowBlock, 16)
+		client, server := net.Pipe()
+		defer client.Close()
+		p.Add(server)
+
+		go func() {
+			for _, msg := range msgs {
+				p.Broadcast(msg)
+			}
+		}()
+
+		lines := readLines(client)
+		if strings.Join(lines, ",") != strings.Join(msgs, ",") {
+			t.Fatalf("expected %v, got %v", msgs, lines)
+		}
+
+		if n := p.Dropped(); n != 0 {
+			t.Fatalf("expected no drops, got %d", n)
+		}
+	})
+
+	t.Run("block-unlocked", func(t *testing.T) {
+		p := newConnPool(1, SlowBlock, 16)
+		client, server := net.Pipe()
+		p.Add(server)
+
+		blocked := make(chan struct{})
+		go func() {
+			defer close(blocked)
+			for _, msg := range msgs {
+				p.Broadcast(msg)
+			}
+		}()
+
+		// A blocked Broadcast doesn't hold the pool
+		otherClient, other := net.Pipe()
+		defer otherClient.Close()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			p.Add(other)
+			p.Conns()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("pool is locked by a blocked Broadcast")
+		}
+
+		// The writers fail and discard their queues, unblocking Broadcast
+		client.Close()
+		otherClient.Close()
+		select {
+		case <-blocked:
+		case <-time.After(time.Second):
+			t.Fatal("Broadcast is still blocked")
+		}
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		p := newConnPool(2, SlowDropOldest, 16)
+		client, server := net.Pipe()
+		defer client.Close()
+		p.Add(server)
+
+		for _, msg := range msgs {
+			p.Broadcast(msg)
+		}
+
+		lines := readLines(client)
+		if len(lines) == 0 || lines[len(lines)-1] != "U1" {
+			t.Fatalf("expected last event to be U1, got %v", lines)
+		}
+
+		if n := p.Dropped(); n+len(lines) != len(msgs) {
+			t.Fatalf("%d dropped + %d received != %d", n, len(lines), len(msgs))
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		p := newConnPool(1, SlowDisconnect, 16)
+		client, server := net.Pipe()
+		defer client.Close()
+		p.Add(server)
+
+		for _, msg := range msgs {
+			p.Broadcast(msg)
+		}
+
+		if n := p.Dropped(); n == 0 {
+			t.Fatal("expected drops")
+		}
+
+		// Events after the disconnect are not counted
+		if c := p.Conns()[0]; c.Events >= len(msgs) {
+			t.Fatalf("expected less than %d events, got %d", len(msgs), c.Events)
+		}
+
+		if _, err := server.Write([]byte("x")); err == nil {
+			t.Fatal("expected connection to be closed")
+		}
+	})
+}
+
+func TestConnPool_Resume(t *testing.T) {
+	p := newConnPool(16, SlowBlock, 3)
+	for _, msg := range []string{"P1", "P2", "P3", "P4"} {
+		p.Broadcast(msg)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	p.Add(server)
+
+	if err := p.Resume(server, 0); !errors.Is(err, errResumeGap) {
+		t.Fatalf("expected gap error, got %v", err)
+	}
+
+	if err := p.Resume(server, 5); err == nil {
+		t.Fatal("expected error on future sequence")
+	}
+
+	if err := p.Resume(server, 2); err != nil {
+		t.Fatal(err)
+	}
+	p.Broadcast("U1")
+
+	expected := []string{"OK RESUME 4", "P3 #3", "P4 #4", "U1 #5"}
+	lines := readLines(client)
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestConnPool_EnableSeq(t *testing.T) {
+	p := newConnPool(16, SlowBlock, 16)
+	p.Broadcast("P1")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	p.Add(server)
+
+	p.Broadcast("P2")
+	p.EnableSeq(server)
+	p.Broadcast("P3")
+
+	expected := []string{"P2", "OK SEQ 2", "P3 #3"}
+	lines := readLines(client)
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestConnPool_Heartbeat(t *testing.T) {
+	p := newConnPool(16, SlowBlock, 16)
+	client, server := net.Pipe()
+	defer client.Close()
+	p.Add(server)
+	p.Claim(server)
+
+	now := time.Now()
+	p.Heartbeat(now, time.Second)
+	if lines := readLines(client); len(lines) != 1 || lines[0] != "PING" {
+		t.Fatalf("expected PING, got %v", lines)
+	}
+
+	p.Heartbeat(now.Add(500*time.Millisecond), time.Second)
+	if p.UnhealthyController() {
+		t.Fatal("unhealthy before timeout")
+	}
+
+	p.Heartbeat(now.Add(2*time.Second), time.Second)
+	if !p.UnhealthyController() {
+		t.Fatal("healthy after timeout")
+	}
+
+	p.Pong(server)
+	if p.UnhealthyController() {
+		t.Fatal("unhealthy after PONG")
+	}
+}
+
+func TestConnPool_Subscribe(t *testing.T) {
+	p := newConnPool(16, SlowBlock, 16)
+	client, server := net.Pipe()
+	defer client.Close()
+	p.Add(server)
+
+	if subs := p.Subscribe(server, ClassButtons, false); subs != ClassApproach|ClassStop|ClassDoor {
+		t.Fatalf("bad subscriptions: %s", subs)
+	}
+
+	if subs := p.Subscribe(server, ClassTick, true); subs != ClassApproach|ClassStop|ClassDoor|ClassTick {
+		t.Fatalf("bad subscriptions: %s", subs)
+	}
+
+	for _, msg := range []string{"P1", "T", "A2", "CRASH door command while moving", "O2"} {
+		p.Broadcast(msg)
+	}
+
+	expected := []string{"T", "A2", "O2"}
+	lines := readLines(client)
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
@@ -0,0 +1,75 @@
+package sim
+
+import (
+	"errors"
+	"time"
+)
+
+var errRateLimit = errors.New("rate limit exceeded")
+
+// tokenBucket is a token bucket rate limiter, it's not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64 // bucket size
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// Allow reports if there's a token at time now, and takes it.
+func (b *tokenBucket) Allow(now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// rateGuard limits the command rate of a connection.
+// It asks to disconnect when the connection keeps exceeding the rate for longer than maxViolation.
+type rateGuard struct {
+	bucket       *tokenBucket
+	maxViolation time.Duration
+	violating    time.Time // start of current violation, zero if none
+	lastReject   time.Time
+}
+
+// A violation ends after a quiet period without rejected commands
+const violationQuiet = time.Second
+
+func newRateGuard(rate float64, burst int, maxViolation time.Duration, now time.Time) *rateGuard {
+	return &rateGuard{
+		bucket:       newTokenBucket(rate, burst, now),
+		maxViolation: maxViolation,
+	}
+}
+
+// Check returns true if the command is allowed and whether the connection should be disconnected.
+func (g *rateGuard) Check(now time.Time) (allowed, disconnect bool) {
+	if !g.violating.IsZero() && now.Sub(g.lastReject) > violationQuiet {
+		g.violating = time.Time{}
+	}
+
+	if g.bucket.Allow(now) {
+		return true, false
+	}
+
+	if g.violating.IsZero() {
+		g.violating = now
+	}
+	g.lastReject = now
+
+	return false, now.Sub(g.violating) > g.maxViolation
+}
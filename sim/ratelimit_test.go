@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 3, now)
+
+	for i := range 3 {
+		if !b.Allow(now) {
+			t.Fatalf("%d: not allowed in burst", i)
+		}
+	}
+
+	if b.Allow(now) {
+		t.Fatal("allowed after burst")
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if !b.Allow(now) {
+		t.Fatal("not allowed after refill")
+	}
+
+	if b.Allow(now) {
+		t.Fatal("allowed more than refill")
+	}
+
+	now = now.Add(time.Hour)
+	for i := range 3 {
+		if !b.Allow(now) {
+			t.Fatalf("%d: not allowed after long wait", i)
+		}
+	}
+
+	if b.Allow(now) {
+		t.Fatal("bucket grew beyond burst")
+	}
+}
+
+func TestRateGuard(t *testing.T) {
+	now := time.Now()
+	g := newRateGuard(1, 1, time.Second, now)
+
+	if ok, _ := g.Check(now); !ok {
+		t.Fatal("first command not allowed")
+	}
+
+	if ok, disconnect := g.Check(now); ok || disconnect {
+		t.Fatalf("expected reject without disconnect, got ok=%v disconnect=%v", ok, disconnect)
+	}
+
+	// Quiet period ends the violation
+	now = now.Add(2 * time.Second)
+	g.Check(now)
+	if ok, disconnect := g.Check(now); ok || disconnect {
+		t.Fatalf("expected reject without disconnect, got ok=%v disconnect=%v", ok, disconnect)
+	}
+
+	// Sustained violation, some commands are allowed as the bucket refills
+	disconnect := false
+	for range 200 {
+		now = now.Add(10 * time.Millisecond)
+		if _, disconnect = g.Check(now); disconnect {
+			break
+		}
+	}
+
+	if !disconnect {
+		t.Fatal("expected disconnect")
+	}
+}
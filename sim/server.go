@@ -0,0 +1,419 @@
+package sim
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Listen listens on addr and serves clients in the background.
+// addr is "host:port", "tcp://host:port", "unix:/path/to/socket" or "unix:///path/to/socket",
+// with an optional initial role for its connections (e.g. "tcp://localhost:10001?role=observer").
+// Use "localhost:0" to get a free port, the address is in the returned listener.
+// With Options.TLS the listener serves TLS.
+func (s *Sim) Listen(addr string) (net.Listener, error) {
+	spec, err := parseListen(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lis, err := spec.Listen()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.opts.TLS != nil {
+		lis = tls.NewListener(lis, s.opts.TLS)
+	}
+
+	go s.serve(lis, spec.role)
+	return lis, nil
+}
+
+// Serve accepts connections on lis until lis or the simulator is closed.
+func (s *Sim) Serve(lis net.Listener) error {
+	return s.serve(lis, RoleAuto)
+}
+
+// serve is Serve with an initial role for connections.
+// A failed Accept (e.g. too many open files) is retried with backoff.
+func (s *Sim) serve(lis net.Listener, role Role) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		lis.Close()
+		return net.ErrClosed
+	}
+	s.listeners[lis] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, lis)
+		s.mu.Unlock()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			delay = acceptBackoff(delay)
+			s.opts.Logf("accept: %s (retrying in %v)", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		go s.serveConn(conn, role)
+	}
+}
+
+// acceptBackoff returns the next delay after a failed Accept.
+func acceptBackoff(delay time.Duration) time.Duration {
+	const maxDelay = time.Second
+
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+
+	return min(2*delay, maxDelay)
+}
+
+// ServeConn serves a single client connection until it's closed.
+// The connection starts with RoleAuto: it becomes the controller on its first command if there's none.
+func (s *Sim) ServeConn(conn net.Conn) {
+	s.serveConn(conn, RoleAuto)
+}
+
+// serveConn adds conn to the pool and handles it.
+// TLS connections get their name and role from the client certificate.
+// When there's a token, connections must authenticate before they're added to the pool.
+func (s *Sim) serveConn(conn net.Conn, role Role) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.handlers.Add(1)
+	s.mu.Unlock()
+	defer s.handlers.Done()
+
+	// A bug in connection handling should not crash the simulator
+	defer func() {
+		if r := recover(); r != nil {
+			s.opts.Logf("%s: panic: %v", conn.RemoteAddr(), r)
+			s.pool.CountError()
+			conn.Close()
+		}
+	}()
+
+	var name string
+	if tconn, ok := conn.(*tls.Conn); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := tconn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			s.opts.Logf("%s: TLS handshake: %s", conn.RemoteAddr(), err)
+			s.pool.CountError()
+			conn.Close()
+			return
+		}
+
+		var certRole Role
+		name, certRole = certIdentity(tconn.ConnectionState())
+		if certRole != RoleAuto {
+			role = certRole
+		}
+	}
+
+	if s.opts.Token != "" {
+		if err := authenticate(conn, s.opts.Token, s.opts.AuthTimeout); err != nil {
+			s.opts.Logf("%s: authentication: %s", conn.RemoteAddr(), err)
+			s.pool.CountError()
+			write(conn, fmt.Sprintf("ERR AUTH: %s", err))
+			conn.Close()
+			return
+		}
+
+		if err := write(conn, "OK AUTH"); err != nil {
+			conn.Close()
+			return
+		}
+	}
+
+	s.pool.Add(conn)
+	if name != "" {
+		if err := s.pool.SetName(conn, name); err != nil {
+			s.opts.Logf("%s: %s", conn.RemoteAddr(), err)
+		}
+	}
+
+	if role != RoleAuto {
+		if _, err := s.pool.SetRole(conn, role); err != nil {
+			s.opts.Logf("%s: %s", conn.RemoteAddr(), err)
+		}
+	}
+
+	s.handler(conn)
+}
+
+// connClosed reports if err is from a closed connection and not a real error.
+// net.ErrClosed is when we close the connection (e.g. failed write),
+// ECONNRESET is when a client exits with unread events.
+func connClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// handler reads commands from conn until it's closed, lines longer than Options.MaxLine close the connection.
+func (s *Sim) handler(conn net.Conn) {
+	defer s.pool.Remove(conn)
+	defer conn.Close()
+
+	maxLine := s.opts.MaxLine
+	if maxLine <= 0 {
+		maxLine = bufio.MaxScanTokenSize
+	}
+
+	var guard *rateGuard
+	if s.opts.Rate > 0 {
+		guard = newRateGuard(s.opts.Rate, s.opts.Burst, s.opts.RateDisconnect, time.Now())
+	}
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0, min(maxLine, 4096)), maxLine)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		// Protocol commands (e.g. STATE) are rate limited too
+		if guard != nil {
+			allowed, disconnect := guard.Check(time.Now())
+			if disconnect {
+				s.opts.Logf("%s: rate limit exceeded for too long, disconnecting", conn.RemoteAddr())
+				s.pool.CountRateLimited()
+				s.pool.CountError()
+				// The writer might not get to it before we close the connection
+				write(conn, fmt.Sprintf("ERR %s: %s, disconnecting", line, errRateLimit))
+				return
+			}
+
+			if !allowed {
+				s.pool.CountRateLimited()
+				s.replyErr(conn, line, errRateLimit)
+				continue
+			}
+		}
+
+		if s.protocolCmd(conn, line) {
+			continue
+		}
+
+		if err := s.pool.Claim(conn).Allowed(line); err != nil {
+			s.replyErr(conn, line, err)
+			continue
+		}
+
+		s.pool.CountCommand(conn)
+		evt := s.Handle(line)
+		if s.opts.OnCommand != nil {
+			s.opts.OnCommand(line, evt)
+		}
+	}
+
+	err := sc.Err()
+	switch {
+	case err == nil, connClosed(err):
+		// Connection closed
+	case errors.Is(err, bufio.ErrTooLong):
+		s.opts.Logf("%s: line too long", conn.RemoteAddr())
+		s.pool.CountError()
+		// The writer might not get to it before we close the connection
+		write(conn, fmt.Sprintf("ERR line too long (max is %d bytes)", maxLine))
+	default:
+		s.opts.Logf("%s: %s", conn.RemoteAddr(), err)
+		s.pool.CountError()
+	}
+}
+
+// protocolCmd handles connection level commands such as "ROLE observer".
+// It returns false if line is not a protocol command.
+func (s *Sim) protocolCmd(conn net.Conn, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "ROLE":
+		s.roleCmd(conn, fields[1:])
+	case "NAME":
+		s.nameCmd(conn, fields[1:])
+	case "LOCK":
+		s.lockCmd(conn)
+	case "UNLOCK":
+		s.unlockCmd(conn)
+	case "STATE":
+		s.sendState(conn)
+	case "SUB", "UNSUB":
+		s.subCmd(conn, fields[0], fields[1:])
+	case "PONG":
+		s.pool.Pong(conn)
+	case "SEQ":
+		s.pool.EnableSeq(conn)
+	case "RESUME":
+		s.resumeCmd(conn, fields[1:])
+	case "AUTH":
+		// Authentication is done before the connection gets here
+		s.replyErr(conn, "AUTH", errors.New("not expected"))
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (s *Sim) replyOK(conn net.Conn, format string, args ...any) {
+	s.pool.Send(conn, "OK "+fmt.Sprintf(format, args...))
+}
+
+func (s *Sim) replyErr(conn net.Conn, cmd string, err error) {
+	s.pool.Send(conn, fmt.Sprintf("ERR %s: %s", cmd, err))
+}
+
+// nameCmd handles "NAME [name]", without a name it reports the current name.
+func (s *Sim) nameCmd(conn net.Conn, args []string) {
+	if len(args) == 0 {
+		s.replyOK(conn, "NAME %s", s.pool.Name(conn))
+		return
+	}
+
+	if len(args) > 1 {
+		s.replyErr(conn, "NAME", fmt.Errorf("too many arguments"))
+		return
+	}
+
+	if err := s.pool.SetName(conn, args[0]); err != nil {
+		s.replyErr(conn, "NAME", err)
+		return
+	}
+
+	s.replyOK(conn, "NAME %s", args[0])
+}
+
+// roleCmd handles "ROLE [name]", without a name it reports the current role.
+func (s *Sim) roleCmd(conn net.Conn, args []string) {
+	if len(args) == 0 {
+		s.replyOK(conn, "ROLE %s", s.pool.Role(conn))
+		return
+	}
+
+	if len(args) > 1 {
+		s.replyErr(conn, "ROLE", fmt.Errorf("too many arguments"))
+		return
+	}
+
+	role, err := ParseRole(args[0])
+	if err != nil {
+		s.replyErr(conn, "ROLE", err)
+		return
+	}
+
+	role, err = s.pool.SetRole(conn, role)
+	if err != nil {
+		s.replyErr(conn, "ROLE", err)
+		return
+	}
+
+	s.replyOK(conn, "ROLE %s", role)
+}
+
+// lockCmd handles "LOCK", conn becomes the controller or a standby if there's already one.
+func (s *Sim) lockCmd(conn net.Conn) {
+	role, err := s.pool.SetRole(conn, RoleController)
+	if err != nil {
+		s.replyErr(conn, "LOCK", err)
+		return
+	}
+
+	s.replyOK(conn, "LOCK %s", role)
+}
+
+// unlockCmd handles "UNLOCK", conn releases the controller role (or standby position) and becomes an observer.
+func (s *Sim) unlockCmd(conn net.Conn) {
+	if role := s.pool.Role(conn); role != RoleController && role != RoleStandby {
+		s.replyErr(conn, "UNLOCK", errors.New("not locked"))
+		return
+	}
+
+	if _, err := s.pool.SetRole(conn, RoleObserver); err != nil {
+		s.replyErr(conn, "UNLOCK", err)
+		return
+	}
+
+	s.replyOK(conn, "UNLOCK")
+}
+
+// subCmd handles "SUB [class...]" and "UNSUB [class...]", it replies with the current subscriptions.
+func (s *Sim) subCmd(conn net.Conn, cmd string, args []string) {
+	classes, err := ParseClasses(args)
+	if err != nil {
+		s.replyErr(conn, cmd, err)
+		return
+	}
+
+	subs := s.pool.Subscribe(conn, classes, cmd == "SUB")
+	s.replyOK(conn, "%s %s", cmd, subs)
+}
+
+// resumeCmd handles "RESUME <seq>", it replays events after seq.
+func (s *Sim) resumeCmd(conn net.Conn, args []string) {
+	if len(args) != 1 {
+		s.replyErr(conn, "RESUME", errors.New("missing sequence number"))
+		return
+	}
+
+	seq, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		s.replyErr(conn, "RESUME", fmt.Errorf("bad sequence number: %q", args[0]))
+		return
+	}
+
+	if err := s.pool.Resume(conn, seq); err != nil {
+		s.replyErr(conn, "RESUME", err)
+	}
+}
+
+// sendState sends a "STATE <json>" snapshot of the elevator to conn.
+// The snapshot is queued under s.mu, so it's in order with the events.
+func (s *Sim) sendState(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(s.e.State())
+	if err != nil {
+		s.replyErr(conn, "STATE", err)
+		return
+	}
+
+	s.pool.Send(conn, "STATE "+string(data))
+}
+
+// promoted tells conn it's now the controller and sends it the elevator state.
+func (s *Sim) promoted(conn net.Conn) {
+	s.pool.Send(conn, "PROMOTED")
+	s.sendState(conn)
+}
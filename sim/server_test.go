@@ -0,0 +1,442 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pipeConn serves one side of a pipe with s and returns the other side, after the greeting.
+// The handler is done when the test ends.
+func pipeConn(t *testing.T, s *Sim) (net.Conn, *bufio.Scanner) {
+	t.Helper()
+
+	client, sc := servePipe(t, s)
+	if line := recvLine(t, client, sc); line != Greeting {
+		t.Fatalf("expected %q, got %q", Greeting, line)
+	}
+
+	return client, sc
+}
+
+// servePipe is pipeConn without reading the greeting.
+func servePipe(t *testing.T, s *Sim) (net.Conn, *bufio.Scanner) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.ServeConn(server)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-stopped
+	})
+
+	return client, bufio.NewScanner(client)
+}
+
+// newTestSim returns a simulator that records client commands in the returned channel.
+func newTestSim(t *testing.T, opts Options) (*Sim, <-chan string) {
+	t.Helper()
+
+	cmds := make(chan string, 10)
+	opts.OnCommand = func(cmd, evt string) {
+		cmds <- cmd
+	}
+
+	s := New(opts)
+	t.Cleanup(func() { s.Close() })
+	return s, cmds
+}
+
+func recvLine(t *testing.T, conn net.Conn, s *bufio.Scanner) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if !s.Scan() {
+		t.Fatalf("can't read: %v", s.Err())
+	}
+
+	return s.Text()
+}
+
+// send writes line to conn, failing the test on error.
+func send(t *testing.T, conn net.Conn, line string) {
+	t.Helper()
+
+	if err := write(conn, line); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectCommand fails the test if the next command handled by the simulator is not want.
+func expectCommand(t *testing.T, cmds <-chan string, want string) {
+	t.Helper()
+
+	select {
+	case cmd := <-cmds:
+		if cmd != want {
+			t.Fatalf("expected command %q, got %q", want, cmd)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected command %q, got none", want)
+	}
+}
+
+func TestServer_Roles(t *testing.T) {
+	s, cmds := newTestSim(t, Options{})
+
+	ctrl, _ := pipeConn(t, s)
+	obs, obsS := pipeConn(t, s)
+	pass, passS := pipeConn(t, s)
+
+	send(t, obs, "ROLE observer")
+	if line := recvLine(t, obs, obsS); line != "OK ROLE observer" {
+		t.Fatalf("observer: bad reply: %q", line)
+	}
+
+	send(t, pass, "ROLE passenger")
+	if line := recvLine(t, pass, passS); line != "OK ROLE passenger" {
+		t.Fatalf("passenger: bad reply: %q", line)
+	}
+
+	send(t, ctrl, "MU")
+	expectCommand(t, cmds, "MU")
+
+	send(t, obs, "MD")
+	if line := recvLine(t, obs, obsS); line != "ERR MD: observer can't send commands" {
+		t.Fatalf("observer: bad reply: %q", line)
+	}
+
+	send(t, pass, "DO")
+	if line := recvLine(t, pass, passS); line != "ERR DO: passenger can only press buttons" {
+		t.Fatalf("passenger: bad reply: %q", line)
+	}
+
+	send(t, pass, "U2")
+	expectCommand(t, cmds, "U2")
+}
+
+func TestServer_Standby(t *testing.T) {
+	s, _ := newTestSim(t, Options{})
+
+	primary, ps := pipeConn(t, s)
+	send(t, primary, "LOCK")
+	if line := recvLine(t, primary, ps); line != "OK LOCK controller" {
+		t.Fatalf("primary: bad reply: %q", line)
+	}
+
+	standby, ss := pipeConn(t, s)
+	send(t, standby, "LOCK")
+	if line := recvLine(t, standby, ss); line != "OK LOCK standby" {
+		t.Fatalf("standby: bad reply: %q", line)
+	}
+
+	send(t, standby, "MU")
+	if line := recvLine(t, standby, ss); line != "ERR MU: standby can't send commands" {
+		t.Fatalf("standby: bad reply: %q", line)
+	}
+
+	primary.Close()
+	if line := recvLine(t, standby, ss); line != "PROMOTED" {
+		t.Fatalf("standby: expected PROMOTED, got %q", line)
+	}
+
+	line := recvLine(t, standby, ss)
+	if !strings.HasPrefix(line, "STATE {") {
+		t.Fatalf("standby: expected STATE, got %q", line)
+	}
+
+	var st State
+	if err := json.Unmarshal([]byte(line[len("STATE "):]), &st); err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Floor != 1 {
+		t.Fatalf("bad state: %+v", st)
+	}
+
+	send(t, standby, "UNLOCK")
+	if line := recvLine(t, standby, ss); line != "OK UNLOCK" {
+		t.Fatalf("standby: bad reply: %q", line)
+	}
+
+	send(t, standby, "UNLOCK")
+	if line := recvLine(t, standby, ss); line != "ERR UNLOCK: not locked" {
+		t.Fatalf("standby: bad reply: %q", line)
+	}
+}
+
+func TestServer_Name(t *testing.T) {
+	s, cmds := newTestSim(t, Options{})
+
+	blue, bs := pipeConn(t, s)
+	red, rs := pipeConn(t, s)
+
+	send(t, blue, "NAME team-blue")
+	if line := recvLine(t, blue, bs); line != "OK NAME team-blue" {
+		t.Fatalf("blue: bad reply: %q", line)
+	}
+
+	send(t, red, "NAME team-blue")
+	if line := recvLine(t, red, rs); line != `ERR NAME: "team-blue": name taken` {
+		t.Fatalf("red: bad reply: %q", line)
+	}
+
+	send(t, blue, "P2")
+	expectCommand(t, cmds, "P2")
+	if line := recvLine(t, blue, bs); line != "P2" {
+		t.Fatalf("blue: expected P2, got %q", line)
+	}
+	recvLine(t, red, rs)
+
+	send(t, blue, "NAME")
+	if line := recvLine(t, blue, bs); line != "OK NAME team-blue" {
+		t.Fatalf("blue: bad reply: %q", line)
+	}
+
+	conns := s.Conns()
+	if len(conns) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(conns))
+	}
+
+	// Both connections were added at about the same time
+	if conns[0].Name == "" {
+		conns[0], conns[1] = conns[1], conns[0]
+	}
+
+	c := conns[0]
+	if c.Name != "team-blue" || c.Role != "controller" || c.Commands != 1 || c.Events != 1 {
+		t.Fatalf("bad info: %+v", c)
+	}
+
+	if c := conns[1]; c.Name != "" || c.Commands != 0 || c.Events != 1 {
+		t.Fatalf("bad info: %+v", c)
+	}
+}
+
+func TestServer_Kick(t *testing.T) {
+	s, _ := newTestSim(t, Options{})
+
+	conn, sc := pipeConn(t, s)
+	send(t, conn, "NAME team-blue")
+	recvLine(t, conn, sc)
+
+	if err := s.Kick("team-red", "BYE kicked", time.Second); err == nil {
+		t.Fatal("kicked unknown connection")
+	}
+
+	if err := s.Kick("team-blue", "BYE kicked", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if line := recvLine(t, conn, sc); line != "BYE kicked" {
+		t.Fatalf("bad reply: %q", line)
+	}
+
+	if sc.Scan() {
+		t.Fatalf("expected connection to close, got %q", sc.Text())
+	}
+
+	if n := s.Stats().Connections; n != 0 {
+		t.Fatalf("expected no connections, got %d", n)
+	}
+}
+
+func TestServer_Unsupported(t *testing.T) {
+	s, _ := newTestSim(t, Options{})
+
+	conn, sc := pipeConn(t, s)
+	send(t, conn, "SUB tick")
+	recvLine(t, conn, sc)
+
+	// An unknown command crashes the elevator, like any bad controller command
+	send(t, conn, "SUB crash")
+	recvLine(t, conn, sc)
+	send(t, conn, "XYZ")
+	if line := recvLine(t, conn, sc); !strings.HasPrefix(line, "CRASH ") {
+		t.Fatalf("expected crash, got %q", line)
+	}
+
+	send(t, conn, "ROLE admin")
+	if line := recvLine(t, conn, sc); line != `ERR ROLE: unknown role: "admin"` {
+		t.Fatalf("bad reply: %q", line)
+	}
+
+	send(t, conn, "AUTH s3cr3t")
+	if line := recvLine(t, conn, sc); line != "ERR AUTH: not expected" {
+		t.Fatalf("bad reply: %q", line)
+	}
+}
+
+func TestServer_LineTooLong(t *testing.T) {
+	s, _ := newTestSim(t, Options{MaxLine: 16})
+
+	conn, sc := pipeConn(t, s)
+	go write(conn, strings.Repeat("P", 100))
+
+	line := recvLine(t, conn, sc)
+	if line != "ERR line too long (max is 16 bytes)" {
+		t.Fatalf("bad reply: %q", line)
+	}
+
+	if sc.Scan() {
+		t.Fatalf("expected connection to close, got %q", sc.Text())
+	}
+
+	if n := s.Stats().Errors; n != 1 {
+		t.Fatalf("expected 1 error, got %d", n)
+	}
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	s, cmds := newTestSim(t, Options{Rate: 1, Burst: 2})
+
+	conn, sc := pipeConn(t, s)
+	go func() {
+		for range 3 {
+			write(conn, "P1")
+		}
+	}()
+
+	// Skip the button events
+	line := recvLine(t, conn, sc)
+	for line == "P1" {
+		line = recvLine(t, conn, sc)
+	}
+
+	if line != "ERR P1: rate limit exceeded" {
+		t.Fatalf("bad reply: %q", line)
+	}
+
+	if n := len(cmds); n != 2 {
+		t.Fatalf("expected 2 commands, got %d", n)
+	}
+
+	if n := s.Stats().RateLimited; n != 1 {
+		t.Fatalf("expected 1 rate limited, got %d", n)
+	}
+}
+
+func TestServer_RateLimitProtocol(t *testing.T) {
+	s, _ := newTestSim(t, Options{Rate: 1, Burst: 2})
+
+	conn, sc := pipeConn(t, s)
+	go func() {
+		for _, line := range []string{"SUB tick", "UNSUB tick", "STATE"} {
+			write(conn, line)
+		}
+	}()
+
+	expected := []string{
+		"OK SUB " + (ClassDefault | ClassTick).String(),
+		"OK UNSUB " + ClassDefault.String(),
+		"ERR STATE: rate limit exceeded",
+	}
+	for _, e := range expected {
+		if line := recvLine(t, conn, sc); line != e {
+			t.Fatalf("expected %q, got %q", e, line)
+		}
+	}
+}
+
+func TestServer_Token(t *testing.T) {
+	s, _ := newTestSim(t, Options{Token: "s3cr3t"})
+
+	bad, bs := servePipe(t, s)
+	go write(bad, "AUTH guess")
+	if line := recvLine(t, bad, bs); line != "ERR AUTH: bad token" {
+		t.Fatalf("bad reply: %q", line)
+	}
+
+	good, gs := servePipe(t, s)
+	go write(good, "AUTH s3cr3t")
+	for _, want := range []string{"OK AUTH", Greeting} {
+		if line := recvLine(t, good, gs); line != want {
+			t.Fatalf("expected %q, got %q", want, line)
+		}
+	}
+}
+
+// flakyListener fails the first Accept calls.
+type flakyListener struct {
+	net.Listener
+	fails int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.fails > 0 {
+		l.fails--
+		return nil, errors.New("too many open files")
+	}
+
+	return l.Listener.Accept()
+}
+
+func TestServer_AcceptRetry(t *testing.T) {
+	s, cmds := newTestSim(t, Options{})
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Serve(&flakyListener{lis, 3})
+		close(stopped)
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send(t, conn, "P1")
+	expectCommand(t, cmds, "P1")
+
+	lis.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("listener didn't stop")
+	}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	var delay time.Duration
+	for range 20 {
+		delay = acceptBackoff(delay)
+	}
+
+	if delay != time.Second {
+		t.Fatalf("expected max delay of 1s, got %v", delay)
+	}
+}
+
+func TestConnClosed(t *testing.T) {
+	cases := []struct {
+		err    error
+		closed bool
+	}{
+		{net.ErrClosed, true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{fmt.Errorf("read: %w", syscall.EPIPE), false},
+		{bufio.ErrTooLong, false},
+	}
+
+	for _, tc := range cases {
+		if closed := connClosed(tc.err); closed != tc.closed {
+			t.Errorf("%v: expected %v, got %v", tc.err, tc.closed, closed)
+		}
+	}
+}
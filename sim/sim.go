@@ -0,0 +1,408 @@
+// Package sim is the droopy elevator simulator as a library.
+//
+// A Sim runs in-process: drive it with Step and Press or let Run tick in real time,
+// and serve the droopy protocol to clients with Listen.
+// It speaks the full protocol: roles and locks, SEQ/RESUME, STATE, SUB/UNSUB, heartbeats,
+// authentication and rate limits. The droopy command adds the operator console and the HTTP API.
+package sim
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Greeting is the first line the simulator sends on a connection (after authentication).
+// Clients use it to tell the simulator knows the protocol commands (SEQ, STATE, SUB...),
+// older simulators send nothing and crash on commands they don't know.
+const Greeting = "HELLO"
+
+// NoHistory is the Options.History value for keeping no events, RESUME always fails.
+const NoHistory = -1
+
+// Options are simulator options, zero values get the defaults.
+type Options struct {
+	Tick    time.Duration // time between ticks in Run, defaults to 100ms
+	History int           // number of events to keep for RESUME, defaults to 1024, NoHistory keeps none
+
+	QueueSize  int        // outbound queue size of every connection and Events channel, defaults to 64
+	SlowPolicy SlowPolicy // what to do when a queue is full, defaults to SlowBlock
+
+	TLS            *tls.Config   // listeners serve TLS, a client certificate sets the connection name and role
+	Token          string        // connections must send "AUTH <token>" first (no authentication if empty)
+	AuthTimeout    time.Duration // time to wait for AUTH, defaults to 5s
+	MaxLine        int           // maximal command line length in bytes, defaults to 64KiB
+	Rate           float64       // maximal commands per second per connection (no limit if 0)
+	Burst          int           // command burst size for Rate, defaults to Rate
+	RateDisconnect time.Duration // disconnect connections exceeding Rate for this long, defaults to 5s
+
+	Heartbeat        time.Duration // PING interval in Run (no heartbeats if 0), see Sim.Heartbeat
+	HeartbeatTimeout time.Duration // time to wait for PONG, defaults to 2s
+	Failsafe         bool          // stop at the next floor when the controller misses a heartbeat
+
+	// OnCommand is called with every elevator command from a client and its event (empty if none),
+	// after the command is handled.
+	OnCommand func(cmd, evt string)
+
+	// Logf logs connection problems such as failed authentication, nothing is logged if it's nil.
+	Logf func(format string, args ...any)
+}
+
+// Stats are simulator statistics.
+type Stats struct {
+	Crashes     int `json:"crashes"`
+	Connections int `json:"connections"`
+	Commands    int `json:"commands"`
+	Events      int `json:"events"`
+	Dropped     int `json:"dropped"`      // events dropped due to slow connections
+	Errors      int `json:"errors"`       // connection errors
+	RateLimited int `json:"rate_limited"` // commands rejected by rate limit
+}
+
+// Sim is an in-process simulator, it's safe for concurrent use.
+// Lock order is s.mu before the pool lock.
+type Sim struct {
+	opts Options
+	pool *connPool
+
+	mu        sync.Mutex
+	e         Elevator
+	commands  int  // elevator commands, ticks excluded
+	events    int  // published events, ticks excluded
+	unhealthy bool // the controller missed a heartbeat
+	listeners map[net.Listener]struct{}
+	closed    bool
+
+	handlers sync.WaitGroup // connection handlers
+	done     chan struct{}  // closed by Shutdown
+}
+
+// New returns a new simulator, the elevator is reset.
+func New(opts Options) *Sim {
+	if opts.Tick <= 0 {
+		opts.Tick = 100 * time.Millisecond
+	}
+
+	switch {
+	case opts.History == 0:
+		opts.History = 1024
+	case opts.History < 0:
+		opts.History = 0
+	}
+
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 64
+	}
+
+	if opts.SlowPolicy == 0 {
+		opts.SlowPolicy = SlowBlock
+	}
+
+	if opts.AuthTimeout <= 0 {
+		opts.AuthTimeout = 5 * time.Second
+	}
+
+	if opts.Rate > 0 && opts.Burst <= 0 {
+		opts.Burst = max(1, int(opts.Rate))
+	}
+
+	if opts.RateDisconnect <= 0 {
+		opts.RateDisconnect = 5 * time.Second
+	}
+
+	if opts.HeartbeatTimeout <= 0 {
+		opts.HeartbeatTimeout = 2 * time.Second
+	}
+
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...any) {}
+	}
+
+	s := Sim{
+		opts:      opts,
+		pool:      newConnPool(opts.QueueSize, opts.SlowPolicy, opts.History),
+		listeners: make(map[net.Listener]struct{}),
+		done:      make(chan struct{}),
+	}
+	s.pool.logf = opts.Logf
+	s.pool.greeting = Greeting
+	s.pool.onPromote = func(conn net.Conn) {
+		go s.promoted(conn)
+	}
+	s.e.Reset()
+	return &s
+}
+
+// Handle handles a controller command (e.g. "MU" or "DO"), it returns the event (empty string if no event).
+// Crash events are "CRASH <reason>" and a reset ("R") is "RESET", as sent to clients.
+func (s *Sim) Handle(cmd string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.handle(cmd)
+}
+
+// handle handles cmd, s.mu must be held.
+func (s *Sim) handle(cmd string) string {
+	if cmd != "T" {
+		s.commands++
+	}
+
+	evt := s.e.Handle(cmd)
+	if cmd == "R" {
+		// The elevator doesn't report resets, clients get an event
+		evt = "RESET"
+	}
+
+	return s.publish(evt)
+}
+
+// Step advances the simulation one tick, it returns the event (empty string if no event).
+func (s *Sim) Step() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Ticks are not recorded as events
+	s.pool.Broadcast("T")
+	return s.handle("T")
+}
+
+// Run calls Step every Options.Tick, and Heartbeat every Options.Heartbeat,
+// until ctx is done or the simulator is closed.
+func (s *Sim) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.Tick)
+	defer ticker.Stop()
+
+	var heartbeat <-chan time.Time
+	if s.opts.Heartbeat > 0 {
+		hb := time.NewTicker(s.opts.Heartbeat)
+		defer hb.Stop()
+		heartbeat = hb.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Step()
+		case <-heartbeat:
+			s.Heartbeat()
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Heartbeat sends PING to the connections, a controller that didn't answer the previous PING
+// within Options.HeartbeatTimeout is unhealthy. With Options.Failsafe, the car stops at the next floor
+// when the controller becomes unhealthy.
+func (s *Sim) Heartbeat() {
+	// With SlowBlock, sending PING waits for slow connections
+	s.pool.Heartbeat(time.Now(), s.opts.HeartbeatTimeout)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unhealthy := s.pool.UnhealthyController()
+	if unhealthy && !s.unhealthy && s.opts.Failsafe {
+		s.publish(s.e.Failsafe())
+	}
+	s.unhealthy = unhealthy
+}
+
+// UnhealthyController reports if there's a controller that missed a heartbeat.
+func (s *Sim) UnhealthyController() bool {
+	return s.pool.UnhealthyController()
+}
+
+// IsButton reports if cmd presses a button (e.g. "U3").
+func IsButton(cmd string) bool {
+	if len(cmd) != 2 || cmd[1] < '1' || cmd[1] > '0'+MaxFloor {
+		return false
+	}
+
+	floor := int(cmd[1] - '0')
+	switch cmd[0] {
+	case 'P':
+		return true
+	case 'U':
+		return floor < MaxFloor
+	case 'D':
+		return floor > 1
+	}
+
+	return false
+}
+
+// Press presses a button as a passenger would, button is "Pn", "Un" or "Dn".
+// Unlike Handle, a bad button is an error and not a crash.
+func (s *Sim) Press(button string) error {
+	if !IsButton(button) {
+		return fmt.Errorf("%q: not a button", button)
+	}
+
+	s.Handle(button)
+	return nil
+}
+
+// State returns a snapshot of the elevator state.
+func (s *Sim) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.e.State()
+}
+
+// String returns the elevator status, as shown by the droopy console.
+func (s *Sim) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.e.String()
+}
+
+// Crashes returns the number of crashes since the simulator was created.
+func (s *Sim) Crashes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.e.CrashCount()
+}
+
+// Faults returns the injected faults.
+func (s *Sim) Faults() Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.e.Faults()
+}
+
+// SetFaults sets the injected faults, 0 clears them.
+func (s *Sim) SetFaults(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.e.SetFaults(f)
+}
+
+// Stats returns the simulator statistics.
+func (s *Sim) Stats() Stats {
+	s.mu.Lock()
+	stats := Stats{
+		Crashes:  s.e.CrashCount(),
+		Commands: s.commands,
+		Events:   s.events,
+	}
+	s.mu.Unlock()
+
+	stats.Connections = s.pool.Len()
+	stats.Dropped = s.pool.Dropped()
+	stats.Errors = s.pool.Errors()
+	stats.RateLimited = s.pool.RateLimited()
+	return stats
+}
+
+// Conns returns the metadata of the client connections, oldest first.
+func (s *Sim) Conns() []ConnInfo {
+	return s.pool.Conns()
+}
+
+// Kick disconnects the client with name (or remote address) target after sending it msg (e.g. "BYE kicked").
+// The connection gets up to timeout to get msg.
+func (s *Sim) Kick(target, msg string, timeout time.Duration) error {
+	return s.pool.Kick(target, msg, timeout)
+}
+
+// Events returns a channel of events (without ticks), it's closed when ctx is done or the simulator is closed.
+// Events are queued like connection events, Options.SlowPolicy decides what happens when the reader is slow:
+// the simulator waits (SlowBlock), drops the oldest event (SlowDropOldest) or closes the channel (SlowDisconnect).
+func (s *Sim) Events(ctx context.Context) <-chan string {
+	q := s.pool.Tap(ClassAll &^ ClassTick)
+	ch := make(chan string)
+
+	go func() {
+		defer close(ch)
+		defer s.pool.Untap(q)
+
+		// Wakes pop when ctx is done
+		stop := context.AfterFunc(ctx, q.discard)
+		defer stop()
+
+		for {
+			evt, ok := q.pop()
+			if !ok {
+				return
+			}
+
+			select {
+			case ch <- evt:
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// publish sends evt to clients, crash events are converted to "CRASH <reason>".
+// It returns the converted event, s.mu must be held.
+func (s *Sim) publish(evt string) string {
+	if reason, ok := strings.CutPrefix(evt, "crash:"); ok {
+		evt = "CRASH " + strings.TrimSpace(reason)
+	}
+
+	if evt == "" {
+		return ""
+	}
+
+	s.events++
+	s.pool.Broadcast(evt)
+	return evt
+}
+
+// Close closes the simulator without waiting for clients, see Shutdown.
+func (s *Sim) Close() error {
+	s.Shutdown("closed", 0)
+	return nil
+}
+
+// Shutdown closes the listeners, says "BYE <reason>" to the clients and waits up to timeout
+// for them to get it and for their handlers to return. It stops Run and closes the Events channels.
+func (s *Sim) Shutdown(reason string, timeout time.Duration) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	s.mu.Unlock()
+
+	s.pool.Close("BYE "+reason, timeout)
+
+	wait := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(wait)
+	}()
+
+	select {
+	case <-wait:
+	case <-time.After(timeout):
+		if timeout > 0 {
+			s.opts.Logf("shutdown: timeout waiting for handlers")
+		}
+	}
+}
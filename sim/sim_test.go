@@ -0,0 +1,266 @@
+package sim
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSim_Step(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	if evt := s.Handle("MU"); evt != "" {
+		t.Fatalf("MU: unexpected event: %q", evt)
+	}
+
+	var evts []string
+	for range TicksPerFloor {
+		if evt := s.Step(); evt != "" {
+			evts = append(evts, evt)
+		}
+
+		if len(evts) == 1 && !s.State().Stopping {
+			s.Handle("S")
+		}
+	}
+
+	if fmt.Sprint(evts) != "[A2 S2]" {
+		t.Fatalf("bad events: %v", evts)
+	}
+
+	if st := s.State(); st.Floor != 2 || st.Motor != "OFF" {
+		t.Fatalf("bad state: %+v", st)
+	}
+}
+
+func TestSim_Crash(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	if evt := s.Handle("MD"); evt != "" {
+		t.Fatalf("MD: unexpected event: %q", evt)
+	}
+
+	var evt string
+	for evt == "" {
+		evt = s.Step()
+	}
+
+	if evt != "CRASH into the basement" {
+		t.Fatalf("bad event: %q", evt)
+	}
+
+	if s.Crashes() != 1 || !s.State().Crashed {
+		t.Fatal("not crashed")
+	}
+}
+
+func TestSim_Reset(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	s.Handle("DC") // crash: door already closed
+	if evt := s.Handle("R"); evt != "RESET" {
+		t.Fatalf("expected RESET, got %q", evt)
+	}
+
+	if s.State().Crashed {
+		t.Fatal("crashed after reset")
+	}
+}
+
+func TestSim_Press(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	for _, button := range []string{"P1", "P4", "U1", "U3", "D2", "D4"} {
+		if err := s.Press(button); err != nil {
+			t.Fatalf("%s: %v", button, err)
+		}
+	}
+
+	for _, button := range []string{"U4", "D1", "P5", "P0", "MU", "CP1", ""} {
+		if err := s.Press(button); err == nil {
+			t.Fatalf("%q: expected error", button)
+		}
+	}
+
+	if s.Crashes() != 0 {
+		t.Fatal("crashed")
+	}
+
+	st := s.State()
+	if fmt.Sprint(st.Panel, st.Up, st.Down) != "[1 4] [1 3] [2 4]" {
+		t.Fatalf("bad buttons: %+v", st)
+	}
+}
+
+func TestSim_Events(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	evts := s.Events(ctx)
+
+	s.Press("P3")
+	s.Handle("DO")
+	for range TicksPerDoor + 1 {
+		s.Step()
+	}
+
+	for _, want := range []string{"P3", "O1"} {
+		select {
+		case evt := <-evts:
+			if evt != want {
+				t.Fatalf("expected %q, got %q", want, evt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+
+	cancel()
+	for range evts {
+	}
+}
+
+func TestSim_Listen(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	lis, err := s.Listen("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(conn)
+
+	expect := func(cmd, want string) string {
+		t.Helper()
+		if cmd != "" {
+			fmt.Fprintln(conn, cmd)
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, want) {
+			t.Fatalf("%q: expected %q, got %q", cmd, want, line)
+		}
+		return line
+	}
+
+	expect("", Greeting)
+	expect("SEQ", "OK SEQ 0")
+	expect("P2", "P2 #1")
+	expect("SUB tick", "OK SUB buttons,approach,stop,door,tick")
+	s.Step()
+	expect("", "T")
+
+	line := expect("STATE", "STATE ")
+	var st State
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "STATE ")), &st); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(st.Panel) != "[2]" {
+		t.Fatalf("bad state: %+v", st)
+	}
+
+	expect("RESUME 0", "OK RESUME 1")
+	expect("", "P2 #1")
+	expect("SUB doors", "ERR SUB:")
+}
+
+func TestSim_NoHistory(t *testing.T) {
+	s := New(Options{History: NoHistory})
+	defer s.Close()
+
+	conn, sc := pipeConn(t, s)
+	send(t, conn, "SEQ")
+	if line := recvLine(t, conn, sc); line != "OK SEQ 0" {
+		t.Fatalf("bad reply: %q", line)
+	}
+
+	s.Press("P2")
+	if line := recvLine(t, conn, sc); line != "P2 #1" {
+		t.Fatalf("bad event: %q", line)
+	}
+
+	send(t, conn, "RESUME 0")
+	if line := recvLine(t, conn, sc); !strings.HasPrefix(line, "ERR RESUME:") {
+		t.Fatalf("expected ERR RESUME, got %q", line)
+	}
+}
+
+func TestSim_EventsSlow(t *testing.T) {
+	s := New(Options{QueueSize: 2, SlowPolicy: SlowDisconnect})
+	defer s.Close()
+
+	evts := s.Events(context.Background())
+	for _, button := range []string{"P1", "P2", "P3", "P4", "U1", "U2"} {
+		s.Press(button)
+	}
+
+	n := 0
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-evts:
+			if !ok {
+				if n >= 6 || s.Stats().Dropped == 0 {
+					t.Fatalf("expected drops, got %d events", n)
+				}
+				return
+			}
+			n++
+		case <-timeout:
+			t.Fatal("slow reader not disconnected")
+		}
+	}
+}
+
+func TestSim_ListenUnix(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "droopy.sock")
+	if _, err := s.Listen("unix://" + path + "?role=observer"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	fmt.Fprintln(conn, "MU")
+	r := bufio.NewReader(conn)
+	for _, want := range []string{Greeting, "ERR MU: observer can't send commands"} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if line != want+"\n" {
+			t.Fatalf("expected %q, got %q", want, line)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package droopy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Direction is the moving direction of the car.
+type Direction byte
+
+const (
+	DirectionNone Direction = iota // Not moving
+	DirectionUp
+	DirectionDown
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionNone:
+		return "NONE"
+	case DirectionUp:
+		return "UP"
+	case DirectionDown:
+		return "DOWN"
+	}
+
+	return fmt.Sprintf("Direction(%d)", d)
+}
+
+// DoorState is the state of the car door.
+type DoorState byte
+
+const (
+	DoorStateClosed DoorState = iota
+	DoorStateOpening
+	DoorStateOpen
+	DoorStateClosing
+)
+
+func (s DoorState) String() string {
+	switch s {
+	case DoorStateClosed:
+		return "CLOSED"
+	case DoorStateOpening:
+		return "OPENING"
+	case DoorStateOpen:
+		return "OPEN"
+	case DoorStateClosing:
+		return "CLOSING"
+	}
+
+	return fmt.Sprintf("DoorState(%d)", s)
+}
+
+// StateView is a copy of State at a point in time.
+type StateView struct {
+	Floor     int // Current floor, or the floor the car is approaching
+	Door      DoorState
+	Direction Direction
+	Stopping  bool // Stop was sent, the car stops at the next floor
+	Crashed   bool
+
+	// Lit buttons by floor, index 0 is not used
+	Panel [MaxFloor + 1]bool
+	Up    [MaxFloor + 1]bool
+	Down  [MaxFloor + 1]bool
+}
+
+// Moving reports if the car is moving.
+func (v StateView) Moving() bool {
+	return v.Direction != DirectionNone
+}
+
+// resetView is the state after the simulator starts or resets.
+var resetView = StateView{Floor: 1}
+
+// State mirrors the simulator state from events and sent commands, it's safe for concurrent use.
+type State struct {
+	mu      sync.Mutex
+	view    StateView
+	changed chan struct{} // closed on change
+}
+
+// NewState returns a State of a simulator that was just reset.
+func NewState() *State {
+	return &State{
+		view:    resetView,
+		changed: make(chan struct{}),
+	}
+}
+
+// View returns a copy of the current state.
+func (s *State) View() StateView {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.view
+}
+
+// Changed returns a channel that's closed on the next state change.
+func (s *State) Changed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+// update calls fn with the view and notifies if it changed.
+func (s *State) update(fn func(v *StateView)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.view
+	fn(&s.view)
+	if s.view != old {
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+}
+
+// ApplyEvent updates the state from an event.
+func (s *State) ApplyEvent(evt Event) {
+	if evt.Kind.HasFloor() && (evt.Floor < 1 || evt.Floor > MaxFloor) {
+		return
+	}
+
+	s.update(func(v *StateView) {
+		switch evt.Kind {
+		case PanelPressed, PanelCleared:
+			v.Panel[evt.Floor] = evt.Kind == PanelPressed
+		case UpPressed, UpCleared:
+			v.Up[evt.Floor] = evt.Kind == UpPressed
+		case DownPressed, DownCleared:
+			v.Down[evt.Floor] = evt.Kind == DownPressed
+		case Approaching:
+			v.Floor = evt.Floor
+		case Stopped:
+			v.Floor = evt.Floor
+			v.Direction = DirectionNone
+			v.Stopping = false
+		case DoorOpened:
+			v.Door = DoorStateOpen
+		case DoorClosed:
+			v.Door = DoorStateClosed
+		case Crashed:
+			v.Crashed = true
+		case Reset:
+			*v = resetView
+		case StateReceived:
+			if evt.Snapshot != nil {
+				*v = snapshotView(*evt.Snapshot)
+			}
+		}
+	})
+}
+
+// ApplyCommand updates the state from a command sent to the simulator.
+func (s *State) ApplyCommand(cmd Command) {
+	if cmd.Validate() != nil {
+		return
+	}
+
+	s.update(func(v *StateView) {
+		switch cmd.Op {
+		case OpMoveUp:
+			v.Direction = DirectionUp
+		case OpMoveDown:
+			v.Direction = DirectionDown
+		case OpStop:
+			v.Stopping = true
+		case OpOpenDoor:
+			v.Door = DoorStateOpening
+		case OpCloseDoor:
+			v.Door = DoorStateClosing
+		case OpPressPanel, OpClearPanel:
+			v.Panel[cmd.Floor] = cmd.Op == OpPressPanel
+		case OpPressUp, OpClearUp:
+			v.Up[cmd.Floor] = cmd.Op == OpPressUp
+		case OpPressDown, OpClearDown:
+			v.Down[cmd.Floor] = cmd.Op == OpPressDown
+		case OpReset:
+			*v = resetView
+		}
+	})
+}
+
+// snapshotView converts a simulator snapshot to a view.
+func snapshotView(s Snapshot) StateView {
+	v := StateView{
+		Floor:    s.Floor,
+		Stopping: s.Stopping,
+		Crashed:  s.Crashed,
+	}
+
+	switch s.Motor {
+	case "UP":
+		v.Direction = DirectionUp
+	case "DOWN":
+		v.Direction = DirectionDown
+	}
+
+	switch s.Door {
+	case "OPENING":
+		v.Door = DoorStateOpening
+	case "OPEN":
+		v.Door = DoorStateOpen
+	case "CLOSING":
+		v.Door = DoorStateClosing
+	}
+
+	lit := func(buttons *[MaxFloor + 1]bool, floors []int) {
+		for _, f := range floors {
+			if f >= 1 && f <= MaxFloor {
+				buttons[f] = true
+			}
+		}
+	}
+	lit(&v.Panel, s.Panel)
+	lit(&v.Up, s.Up)
+	lit(&v.Down, s.Down)
+
+	return v
+}
+
+// ErrUnsafe is returned by a guarded client for commands that would crash the elevator.
+var ErrUnsafe = errors.New("unsafe command")
+
+// Check returns an error wrapping ErrUnsafe if cmd would crash the elevator in this state.
+// It follows the simulator crash rules, e.g. opening the door while moving.
+func (v StateView) Check(cmd Command) error {
+	var why string
+	switch cmd.Op {
+	case OpOpenDoor, OpCloseDoor:
+		want := DoorStateClosed
+		if cmd.Op == OpCloseDoor {
+			want = DoorStateOpen
+		}
+
+		switch {
+		case v.Moving():
+			why = "while moving"
+		case v.Door != want:
+			why = fmt.Sprintf("with door %s", v.Door)
+		}
+	case OpMoveUp, OpMoveDown:
+		switch {
+		case v.Moving():
+			why = "while moving"
+		case v.Door != DoorStateClosed:
+			why = fmt.Sprintf("with door %s", v.Door)
+		case cmd.Op == OpMoveUp && v.Floor == MaxFloor:
+			why = "at top floor"
+		case cmd.Op == OpMoveDown && v.Floor == 1:
+			why = "at bottom floor"
+		}
+	case OpStop:
+		switch {
+		case !v.Moving():
+			why = "while not moving"
+		case v.Stopping:
+			why = "while stopping"
+		}
+	}
+
+	if why == "" {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s %s", ErrUnsafe, cmd, why)
+}
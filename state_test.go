@@ -0,0 +1,117 @@
+package droopy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	s := NewState()
+
+	s.ApplyEvent(Event{Kind: UpPressed, Floor: 3})
+	s.ApplyCommand(MoveUp())
+	s.ApplyEvent(Event{Kind: Approaching, Floor: 2})
+	s.ApplyEvent(Event{Kind: Approaching, Floor: 3})
+	s.ApplyCommand(Stop())
+
+	v := s.View()
+	if v.Floor != 3 || v.Direction != DirectionUp || !v.Stopping || !v.Up[3] {
+		t.Fatalf("moving: bad state: %+v", v)
+	}
+
+	s.ApplyEvent(Event{Kind: Stopped, Floor: 3})
+	s.ApplyCommand(ClearUp(3))
+	s.ApplyCommand(OpenDoor())
+	if v := s.View(); v.Moving() || v.Stopping || v.Door != DoorStateOpening || v.Up[3] {
+		t.Fatalf("stopped: bad state: %+v", v)
+	}
+
+	s.ApplyEvent(Event{Kind: DoorOpened, Floor: 3})
+	if v := s.View(); v.Door != DoorStateOpen {
+		t.Fatalf("open: bad state: %+v", v)
+	}
+
+	s.ApplyEvent(Event{Kind: Reset})
+	if v := s.View(); v != resetView {
+		t.Fatalf("reset: bad state: %+v", v)
+	}
+}
+
+func TestState_Snapshot(t *testing.T) {
+	s := NewState()
+	snap := Snapshot{Floor: 2, Motor: "DOWN", Door: "CLOSED", Stopping: true, Panel: []int{1}, Down: []int{4}}
+	s.ApplyEvent(Event{Kind: StateReceived, Snapshot: &snap})
+
+	v := s.View()
+	if v.Floor != 2 || v.Direction != DirectionDown || !v.Stopping || !v.Panel[1] || !v.Down[4] || v.Up[1] {
+		t.Fatalf("bad state: %+v", v)
+	}
+}
+
+func TestState_Changed(t *testing.T) {
+	s := NewState()
+	changed := s.Changed()
+
+	s.ApplyEvent(Event{Kind: DoorClosed, Floor: 1}) // already closed
+	select {
+	case <-changed:
+		t.Fatal("notified without a change")
+	default:
+	}
+
+	go s.ApplyEvent(Event{Kind: PanelPressed, Floor: 4})
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("not notified on change")
+	}
+
+	if !s.View().Panel[4] {
+		t.Fatal("P4 not lit")
+	}
+}
+
+func TestStateView_Check(t *testing.T) {
+	moving := StateView{Floor: 2, Direction: DirectionUp}
+	open := StateView{Floor: 2, Door: DoorStateOpen}
+	top := StateView{Floor: MaxFloor}
+
+	cases := []struct {
+		name string
+		view StateView
+		cmd  Command
+		err  string // empty for safe commands
+	}{
+		{"open", resetView, OpenDoor(), ""},
+		{"close", open, CloseDoor(), ""},
+		{"move", resetView, MoveUp(), ""},
+		{"stop", moving, Stop(), ""},
+		{"clear", moving, ClearPanel(3), ""},
+		{"open moving", moving, OpenDoor(), "unsafe command: DO while moving"},
+		{"open open", open, OpenDoor(), "unsafe command: DO with door OPEN"},
+		{"close closed", resetView, CloseDoor(), "unsafe command: DC with door CLOSED"},
+		{"move moving", moving, MoveDown(), "unsafe command: MD while moving"},
+		{"move open", open, MoveUp(), "unsafe command: MU with door OPEN"},
+		{"roof", top, MoveUp(), "unsafe command: MU at top floor"},
+		{"basement", resetView, MoveDown(), "unsafe command: MD at bottom floor"},
+		{"stop idle", resetView, Stop(), "unsafe command: S while not moving"},
+		{"stop stopping", StateView{Floor: 2, Direction: DirectionUp, Stopping: true}, Stop(), "unsafe command: S while stopping"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.view.Check(tc.cmd)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrUnsafe) || err.Error() != tc.err {
+				t.Fatalf("expected %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package droopy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/353solutions/droopy/sim"
+)
+
+// testCA is a certificate authority for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newCertificate(t *testing.T, tmpl *x509.Certificate, parent *testCA) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial++
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return cert, key, certPEM, keyPEM
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	tmpl := x509.Certificate{
+		Subject:               pkix.Name{CommonName: "droopy test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	cert, key, certPEM, _ := newCertificate(t, &tmpl, nil)
+	return &testCA{cert, key, certPEM}
+}
+
+// issue returns a certificate and key (in PEM format) signed by ca.
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, server bool) ([]byte, []byte) {
+	t.Helper()
+
+	tmpl := x509.Certificate{
+		Subject:     subject,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.DNSNames = []string{"localhost"}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+
+	_, _, certPEM, keyPEM := newCertificate(t, &tmpl, ca)
+	return certPEM, keyPEM
+}
+
+func TestClient_TLS(t *testing.T) {
+	ca := newTestCA(t)
+
+	certPEM, keyPEM := ca.issue(t, pkix.Name{CommonName: "localhost"}, true)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	serverCfg := tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    roots,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+	addr := startSim(t, sim.Options{TLS: &serverCfg}, "localhost:0")
+
+	clientPEM, clientKey := ca.issue(t, pkix.Name{CommonName: "team-blue", OrganizationalUnit: []string{"observer"}}, false)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+	}
+
+	c, err := NewClient(WithAddr(addr), WithTLS(&cfg))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Send("ROLE"); err != nil {
+		t.Fatalf("failed to send ROLE: %v", err)
+	}
+
+	evt, err := c.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive reply: %v", err)
+	}
+
+	if evt != "OK ROLE observer" {
+		t.Fatalf("expected observer role from certificate, got %q", evt)
+	}
+
+	// No client certificate
+	cfg = tls.Config{RootCAs: roots}
+	c, err = NewClient(WithAddr(addr), WithTLS(&cfg))
+	expectRejected(t, c, err)
+}